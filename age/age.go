@@ -0,0 +1,80 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package age
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+var (
+	binaryHeader = []byte("age-encryption.org/v1\n")
+	armorHeader  = []byte(armor.Header)
+)
+
+// Decrypter represents a decrypter for age-encrypted payloads.
+type Decrypter struct {
+	identities  []age.Identity // The identities to decrypt with
+	passthrough bool           // Whether non-encrypted payloads are returned as-is
+}
+
+// New creates a new decrypter from one or more age identities, one per line, in the same
+// format as the identity files produced by age-keygen.
+func New(keys string, options ...func(*Decrypter)) (*Decrypter, error) {
+	identities, err := age.ParseIdentities(strings.NewReader(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithIdentities(identities, options...), nil
+}
+
+// NewWithIdentities creates a new decrypter with the supplied age identities.
+func NewWithIdentities(identities []age.Identity, options ...func(*Decrypter)) *Decrypter {
+	d := &Decrypter{
+		identities: identities,
+	}
+
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// WithPassthrough detects the age header and leaves the payloads which are not
+// encrypted untouched, instead of failing to decrypt them.
+func WithPassthrough() func(*Decrypter) {
+	return func(d *Decrypter) {
+		d.passthrough = true
+	}
+}
+
+// Decrypt decrypts an age-encrypted payload, either binary or armored.
+func (d *Decrypter) Decrypt(data []byte) ([]byte, error) {
+	armored := isArmored(data)
+	if d.passthrough && !armored && !bytes.HasPrefix(data, binaryHeader) {
+		return data, nil
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if armored {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, d.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// isArmored checks whether the payload is an armored (PEM-like) age file
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), armorHeader)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package age
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecrypt(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	dec, err := New(id.String())
+	assert.NoError(t, err)
+
+	{ // Binary format
+		out, err := dec.Decrypt(encrypt(t, id.Recipient(), false, "hello world"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(out))
+	}
+
+	{ // Armored format
+		out, err := dec.Decrypt(encrypt(t, id.Recipient(), true, "hello world"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(out))
+	}
+
+	{ // Not encrypted
+		out, err := dec.Decrypt([]byte("hello world"))
+		assert.Error(t, err)
+		assert.Nil(t, out)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	dec, err := New(id.String(), WithPassthrough())
+	assert.NoError(t, err)
+
+	{ // Plaintext is left untouched
+		out, err := dec.Decrypt([]byte("hello world"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(out))
+	}
+
+	{ // Encrypted is still decrypted
+		out, err := dec.Decrypt(encrypt(t, id.Recipient(), false, "hello world"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(out))
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	dec, err := New("not a key")
+	assert.Error(t, err)
+	assert.Nil(t, dec)
+}
+
+func encrypt(t *testing.T, recipient age.Recipient, armored bool, plaintext string) []byte {
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopCloser{&out}
+	if armored {
+		dst = armor.NewWriter(&out)
+	}
+
+	w, err := age.Encrypt(dst, recipient)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(plaintext))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, dst.Close())
+	return out.Bytes()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,181 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// ErrNoSuchMember is returned when the member does not exist in the archive
+var ErrNoSuchMember error = resource.NotFoundError("archive member does not exist")
+
+// archiveCapacity is the maximum number of archives kept in the cache
+const archiveCapacity = 64
+
+// archives represents the cache of the downloaded archives, by uri
+type archives struct {
+	lock    sync.Mutex
+	entries map[string]*archive
+}
+
+// archive represents a cached archive
+type archive struct {
+	data      []byte    // The contents of the archive
+	meta      Meta      // The metadata of the archive
+	updatedAt time.Time // The time the contents were downloaded
+	fetchedAt time.Time // The time the archive was last downloaded or validated
+}
+
+// newArchives creates a new cache of the archives
+func newArchives() *archives {
+	return &archives{
+		entries: make(map[string]*archive),
+	}
+}
+
+// load returns the cached archive, if any
+func (a *archives) load(uri string) (*archive, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entry, ok := a.entries[uri]
+	return entry, ok
+}
+
+// store stores the archive, evicting the least recently fetched one if the cache is full
+func (a *archives) store(uri string, entry *archive) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.entries[uri]; !ok && len(a.entries) >= archiveCapacity {
+		oldest := ""
+		for k, v := range a.entries {
+			if oldest == "" || v.fetchedAt.Before(a.entries[oldest].fetchedAt) {
+				oldest = k
+			}
+		}
+		delete(a.entries, oldest)
+	}
+
+	a.entries[uri] = entry
+}
+
+// splitArchive splits the URI of an archive member (e.g. 'data.zip!config.json') into the
+// URI of the archive and the path of the member.
+func splitArchive(uri string) (string, string, bool) {
+	archiveURI, member, ok := strings.Cut(uri, "!")
+	return archiveURI, strings.TrimPrefix(member, "/"), ok && member != ""
+}
+
+// loadMember loads a member of the archive, only if the archive was modified since the
+// specified time. The archive itself is cached, so loading several of its members only
+// downloads it once.
+func (l *Loader) loadMember(ctx context.Context, client Downloader, archiveURI, member string, updatedSince time.Time) ([]byte, Meta, error) {
+	cached, err := l.loadArchive(ctx, client, archiveURI)
+	if err != nil || cached == nil || !cached.updatedAt.After(updatedSince) {
+		return nil, Meta{}, err
+	}
+
+	b, err := extract(archiveURI, cached.data, member, l.maxSize)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+
+	return b, Meta{
+		LastModified: cached.meta.LastModified,
+		Size:         int64(len(b)),
+		ETag:         cached.meta.ETag,
+	}, nil
+}
+
+// loadArchive downloads the archive if it was modified since it was last cached
+func (l *Loader) loadArchive(ctx context.Context, client Downloader, archiveURI string) (*archive, error) {
+	updatedSince, last := zeroTime, Meta{}
+	prev, ok := l.archives.load(archiveURI)
+	if ok {
+		updatedSince, last = prev.updatedAt, prev.meta
+	}
+
+	// The archive is validated against the version cached, not the one seen by the caller
+	now := time.Now()
+	b, meta, err := l.downloadWithRetry(resource.LastSeen(ctx, last), client, archiveURI, updatedSince)
+	switch {
+	case err != nil:
+		return nil, err
+	case b == nil && !ok:
+		return nil, nil
+	}
+
+	next := &archive{data: b, meta: meta, updatedAt: now, fetchedAt: now}
+	if b == nil {
+		next.data, next.meta, next.updatedAt = prev.data, prev.meta, prev.updatedAt
+	}
+
+	l.archives.store(archiveURI, next)
+	return next, nil
+}
+
+// extract reads the member from the archive, based on the extension of the archive. The member
+// is bounded by the limit, if positive, see readAll.
+func extract(archiveURI string, data []byte, member string, limit int64) ([]byte, error) {
+	switch name := strings.ToLower(path.Base(archiveURI)); {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(data, member, limit)
+	case strings.HasSuffix(name, ".tar"):
+		return extractTar(bytes.NewReader(data), member, limit)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+		return extractTar(r, member, limit)
+	default:
+		return nil, fmt.Errorf("archive format of %s is not supported", name)
+	}
+}
+
+// extractZip reads the member from a zip archive
+func extractZip(data []byte, member string, limit int64) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := r.Open(member)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchMember, member)
+	}
+
+	defer f.Close()
+	return readAll(f, limit)
+}
+
+// extractTar reads the member from a tar archive
+func extractTar(r io.Reader, member string, limit int64) ([]byte, error) {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		switch {
+		case err == io.EOF:
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchMember, member)
+		case err != nil:
+			return nil, err
+		case header.Typeflag == tar.TypeReg && path.Clean(header.Name) == path.Clean(member):
+			return readAll(archive, limit)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	members := map[string]string{
+		"config.json":       `{"name":"app"}`,
+		"inner/config.json": `{"name":"inner"}`,
+	}
+
+	for _, name := range []string{"data.zip", "data.tar.gz"} {
+		uri := writeArchive(t, name, members)
+		loader := New(WithArchiveSupport())
+
+		for member, expect := range members {
+			b, err := loader.Load(context.Background(), uri+"!"+member)
+			assert.NoError(t, err, name)
+			assert.Equal(t, expect, string(b), name)
+		}
+
+		_, err := loader.Load(context.Background(), uri+"!missing.json")
+		assert.True(t, errors.Is(err, ErrNoSuchMember), name)
+		assert.True(t, errors.Is(err, fs.ErrNotExist), name)
+	}
+}
+
+func TestArchiveNotModified(t *testing.T) {
+	uri := writeArchive(t, "data.zip", map[string]string{"config.json": "hello"})
+	loader := New(WithArchiveSupport())
+
+	b, err := loader.LoadIf(context.Background(), uri+"!config.json", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	b, err = loader.LoadIf(context.Background(), uri+"!config.json", time.Now())
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+}
+
+func TestArchiveCapacity(t *testing.T) {
+	a := newArchives()
+	for i := 0; i < archiveCapacity+10; i++ {
+		a.store(fmt.Sprintf("file:///%d.zip", i), &archive{
+			fetchedAt: time.Unix(int64(i), 0),
+		})
+	}
+
+	assert.Len(t, a.entries, archiveCapacity)
+	assert.NotContains(t, a.entries, "file:///0.zip")
+	assert.Contains(t, a.entries, fmt.Sprintf("file:///%d.zip", archiveCapacity+9))
+}
+
+func TestArchiveBomb(t *testing.T) {
+	members := map[string]string{
+		"bomb.json":   string(make([]byte, 16<<20)),
+		"config.json": "hello",
+	}
+
+	for _, name := range []string{"data.zip", "data.tar.gz"} {
+		uri := writeArchive(t, name, members)
+		loader := New(WithArchiveSupport(), WithMaxSize(1<<20))
+
+		// The archive is small, but the member inflates beyond the limit
+		_, err := loader.Load(context.Background(), uri+"!bomb.json")
+		assert.ErrorIs(t, err, ErrTooLarge, name)
+
+		b, err := loader.Load(context.Background(), uri+"!config.json")
+		assert.NoError(t, err, name)
+		assert.Equal(t, "hello", string(b), name)
+	}
+}
+
+func TestArchiveUnsupported(t *testing.T) {
+	loader := New(WithDownloader("static", staticDownloader("hello")))
+
+	{ // Without the archive support, the whole URI is downloaded
+		b, err := loader.Load(context.Background(), "static://data.zip!config.json")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	{ // Unknown archive format
+		WithArchiveSupport()(loader)
+		_, err := loader.Load(context.Background(), "static://data.rar!config.json")
+		assert.Error(t, err)
+	}
+}
+
+// writeArchive writes a zip or a gzipped tar archive with the members to a temporary file
+func writeArchive(t *testing.T, name string, members map[string]string) string {
+	var buffer bytes.Buffer
+	switch filepath.Ext(name) {
+	case ".zip":
+		w := zip.NewWriter(&buffer)
+		for member, content := range members {
+			f, err := w.Create(member)
+			assert.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Close())
+	default:
+		gz := gzip.NewWriter(&buffer)
+		w := tar.NewWriter(gz)
+		for member, content := range members {
+			assert.NoError(t, w.WriteHeader(&tar.Header{
+				Name:     member,
+				Mode:     0644,
+				Size:     int64(len(content)),
+				Typeflag: tar.TypeReg,
+			}))
+			_, err := w.Write([]byte(content))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Close())
+		assert.NoError(t, gz.Close())
+	}
+
+	f := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(f, buffer.Bytes(), 0644))
+	return "file:///" + f
+}
@@ -0,0 +1,218 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/kelindar/loader/resource"
+)
+
+var (
+	// ErrNoSuchContainer is returned when the requested container does not exist
+	ErrNoSuchContainer error = resource.NotFoundError("container does not exist")
+
+	// ErrNoSuchKey is returned when the requested file does not exist
+	ErrNoSuchKey error = resource.NotFoundError("key does not exist")
+
+	// ErrNoAccount is returned when neither a connection string nor an account is configured
+	ErrNoAccount = errors.New("neither AZURE_STORAGE_CONNECTION_STRING nor AZURE_STORAGE_ACCOUNT is set")
+)
+
+// Client represents the client implementation for the Azure Blob Storage downloader.
+type Client struct {
+	client *azblob.Client
+}
+
+// New creates a new client for Azure Blob Storage. The connection string is read from the
+// AZURE_STORAGE_CONNECTION_STRING environment variable, otherwise the managed identity is
+// used to access the account named by the AZURE_STORAGE_ACCOUNT environment variable.
+func New() (*Client, error) {
+	if v := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); v != "" {
+		c, err := azblob.NewClientFromConnectionString(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		return NewFromClient(c), nil
+	}
+
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	if account == "" {
+		return nil, ErrNoAccount
+	}
+
+	// Use the managed identity of the host
+	creds, err := azidentity.NewManagedIdentityCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", account), creds, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromClient(c), nil
+}
+
+// NewFromClient creates a new client with the supplied Azure Blob Storage client
+func NewFromClient(client *azblob.Client) *Client {
+	return &Client{
+		client: client,
+	}
+}
+
+// DownloadIf downloads a file only if the updatedSince time is older than the resource
+// timestamp itself.
+func (s *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	b, _, err := s.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the blob along with its contents.
+func (s *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// Get the latest blob
+	blob, err := s.getLatestKey(ctx, bucket, prefix)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// If the latest key is older than the time, skip
+	updatedAt := *blob.Properties.LastModified
+	if !isModified(updatedAt, updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	// Download the blob
+	b, err := s.Download(ctx, bucket, *blob.Name)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	meta := resource.Meta{
+		LastModified: updatedAt,
+		Size:         int64(len(b)),
+	}
+	if blob.Properties.ETag != nil {
+		meta.ETag = string(*blob.Properties.ETag)
+	}
+	if blob.Properties.ContentType != nil {
+		meta.ContentType = *blob.Properties.ContentType
+	}
+	if blob.Properties.ContentEncoding != nil {
+		meta.ContentEncoding = *blob.Properties.ContentEncoding
+	}
+	return b, meta, nil
+}
+
+// Download loads a specified blob from the container
+func (s *Client) Download(ctx context.Context, bucket, key string) ([]byte, error) {
+	resp, err := s.client.DownloadStream(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// getLatestKey returns the latest uploaded blob in given container
+func (s *Client) getLatestKey(ctx context.Context, bucket, prefix string) (*container.BlobItem, error) {
+	pager := s.client.NewListBlobsFlatPager(bucket, &azblob.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+
+	var latest *container.BlobItem
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, convertError(err)
+		}
+
+		for _, o := range page.Segment.BlobItems {
+			if o.Name == nil || o.Properties == nil || o.Properties.LastModified == nil || o.Properties.ContentLength == nil {
+				continue
+			}
+
+			if *o.Properties.ContentLength > 0 && (latest == nil || isModified(*o.Properties.LastModified, *latest.Properties.LastModified)) {
+				latest = o
+			}
+		}
+	}
+
+	if latest == nil {
+		return nil, ErrNoSuchKey
+	}
+	return latest, nil
+}
+
+// List returns the URIs of all of the blobs under the prefix, in lexicographical order.
+func (s *Client) List(ctx context.Context, uri string) ([]string, error) {
+	bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	pager := s.client.NewListBlobsFlatPager(bucket, &azblob.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, convertError(err)
+		}
+
+		for _, o := range page.Segment.BlobItems {
+			if o.Name != nil {
+				keys = append(keys, resource.URIOf(uri, prefix, *o.Name))
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// convertError converts the error
+func convertError(err error) error {
+	switch {
+	case bloberror.HasCode(err, bloberror.ContainerNotFound):
+		return ErrNoSuchContainer
+	case bloberror.HasCode(err, bloberror.BlobNotFound):
+		return ErrNoSuchKey
+	default:
+		return err
+	}
+}
+
+func isModified(updatedAt, updatedSince time.Time) bool {
+	return updatedAt.After(updatedSince)
+}
+
+// parseURI returns container and prefix
+func parseURI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+
+	return u.Host, strings.TrimLeft(u.Path, "/"), nil
+}
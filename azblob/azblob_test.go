@@ -0,0 +1,174 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzure(t *testing.T) {
+	az := new(fakeAzure)
+	az.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(az.serve))
+	defer ts.Close()
+
+	// Test data
+	inputVal := []byte("hello world")
+	os.Setenv("AZURE_STORAGE_CONNECTION_STRING", fmt.Sprintf(
+		"DefaultEndpointsProtocol=http;AccountName=account;AccountKey=%s;BlobEndpoint=%s/account;",
+		base64.StdEncoding.EncodeToString([]byte("key")), ts.URL,
+	))
+	defer os.Unsetenv("AZURE_STORAGE_CONNECTION_STRING")
+
+	// Create a new Azure layer
+	cli, err := New()
+	assert.NotNil(t, cli)
+	assert.NoError(t, err)
+
+	// Add a few objects
+	az.PutObject("hi.txt", inputVal)
+	az.PutObject("hello.txt", inputVal)
+
+	// Test Download
+	{
+		val, err := cli.Download(context.Background(), "container", "hi.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+	}
+
+	// Test DownloadNewer
+	{
+		val, err := cli.DownloadIf(context.Background(), "az://container/h", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+	}
+
+	// Test not modified
+	{
+		val, err := cli.DownloadIf(context.Background(), "az://container/h", time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+	}
+
+	// Test DownloadMeta
+	{
+		val, meta, err := cli.DownloadMeta(context.Background(), "az://container/hi", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+		assert.Equal(t, int64(len(inputVal)), meta.Size)
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum(inputVal)), meta.ETag)
+		assert.Equal(t, "text/plain", meta.ContentType)
+	}
+
+	// Test List
+	{
+		keys, err := cli.List(context.Background(), "az://container/h")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"az://container/hi.txt", "az://container/hello.txt"}, keys)
+	}
+
+	// Test missing key
+	{
+		val, err := cli.DownloadIf(context.Background(), "az://container/missing", time.Unix(0, 0))
+		assert.Equal(t, ErrNoSuchKey, err)
+		assert.Nil(t, val)
+	}
+}
+
+func TestNoAccount(t *testing.T) {
+	os.Unsetenv("AZURE_STORAGE_CONNECTION_STRING")
+	os.Unsetenv("AZURE_STORAGE_ACCOUNT")
+
+	cli, err := New()
+	assert.Equal(t, ErrNoAccount, err)
+	assert.Nil(t, cli)
+}
+
+// fakeAzure represents a fake Azure Blob Storage server
+type fakeAzure struct {
+	sync.Mutex
+	Objects map[string]object
+}
+
+type object struct {
+	Key        string
+	ModifiedAt int64
+	Value      []byte
+}
+
+// serve called on every HTTP request
+func (s *fakeAzure) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "list":
+		s.ListObjects(w, r)
+	case r.Method == http.MethodGet:
+		s.GetObject(w, r)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// ListObjects emulates Azure list blobs
+func (s *fakeAzure) ListObjects(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	prefix := r.URL.Query().Get("prefix")
+	for _, o := range s.Objects {
+		if strings.HasPrefix(o.Key, prefix) {
+			sb.WriteString(fmt.Sprintf(`<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Etag>%x</Etag><Content-Length>%d</Content-Length><Content-Type>text/plain</Content-Type><BlobType>BlockBlob</BlobType></Properties></Blob>`,
+				o.Key,
+				time.Unix(0, o.ModifiedAt).UTC().Format(http.TimeFormat),
+				md5.Sum(o.Value),
+				len(o.Value),
+			))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+	<EnumerationResults ServiceEndpoint="http://localhost/account" ContainerName="container">
+		<Prefix>%s</Prefix>
+		<Blobs>%s</Blobs>
+		<NextMarker/>
+	</EnumerationResults>`,
+		prefix,
+		sb.String(),
+	)))
+}
+
+// PutObject emulates Azure put blob
+func (s *fakeAzure) PutObject(key string, value []byte) {
+	s.Objects[key] = object{
+		Key:        key,
+		ModifiedAt: time.Now().UnixNano(),
+		Value:      value,
+	}
+}
+
+// GetObject emulates Azure get blob
+func (s *fakeAzure) GetObject(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if o, ok := s.Objects[key]; ok {
+		http.ServeContent(w, r, key, time.Unix(0, o.ModifiedAt), bytes.NewReader(o.Value))
+		return
+	}
+
+	w.Header().Set("x-ms-error-code", "BlobNotFound")
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// cacheCapacity is the maximum number of resources kept in the cache
+const cacheCapacity = 1024
+
+// cache represents an in-memory cache of the downloaded resources, by uri
+type cache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry represents a cached resource
+type cacheEntry struct {
+	data      []byte    // The contents of the resource
+	meta      Meta      // The metadata of the resource
+	updatedAt time.Time // The time the contents were last modified, or first seen
+	fetchedAt time.Time // The time the resource was last downloaded or validated
+}
+
+// newCache creates a new cache with the time-to-live of the entries
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// load returns the resource from the cache if it is fresh, otherwise it downloads the resource
+// and caches it. Just like a download, the contents are only returned if they were modified
+// since the specified time. The contents are copied, so the callers can not alter the cache.
+func (c *cache) load(ctx context.Context, uri string, updatedSince time.Time, download func(context.Context, time.Time) ([]byte, Meta, error)) ([]byte, Meta, error) {
+	entry, err := c.fetch(ctx, uri, download)
+	if err != nil || entry == nil || !entry.updatedAt.After(updatedSince) {
+		return nil, Meta{}, err
+	}
+
+	return bytes.Clone(entry.data), entry.meta, nil
+}
+
+// fetch returns the cached entry if it is fresh, otherwise revalidates or downloads it
+func (c *cache) fetch(ctx context.Context, uri string, download func(context.Context, time.Time) ([]byte, Meta, error)) (*cacheEntry, error) {
+	c.lock.Lock()
+	prev, ok := c.entries[uri]
+	c.lock.Unlock()
+
+	// Serve the cached entry until it expires
+	now := time.Now()
+	if ok && now.Sub(prev.fetchedAt) < c.ttl {
+		return prev, nil
+	}
+
+	// Only download the contents if they were modified since they were cached, validated against
+	// the version cached rather than the one seen by the caller
+	updatedSince, last := zeroTime, Meta{}
+	if ok {
+		updatedSince, last = prev.fetchedAt, prev.meta
+	}
+
+	b, meta, err := download(resource.LastSeen(ctx, last), updatedSince)
+	switch {
+	case err != nil:
+		return nil, err
+	case b == nil && !ok:
+		return nil, nil
+	}
+
+	next := &cacheEntry{data: b, meta: meta, updatedAt: meta.LastModified, fetchedAt: now}
+	switch {
+	case b == nil:
+		next.data, next.meta, next.updatedAt = prev.data, prev.meta, prev.updatedAt
+	case next.updatedAt.IsZero():
+		next.updatedAt = now
+	}
+
+	c.store(uri, next)
+	return next, nil
+}
+
+// store stores the entry, evicting the least recently fetched one if the cache is full
+func (c *cache) store(uri string, entry *cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[uri]; !ok && len(c.entries) >= cacheCapacity {
+		oldest := ""
+		for k, v := range c.entries {
+			if oldest == "" || v.fetchedAt.Before(c.entries[oldest].fetchedAt) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+
+	c.entries[uri] = entry
+}
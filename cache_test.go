@@ -0,0 +1,95 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	dl := new(staticCounter)
+	loader := New(WithCache(50*time.Millisecond), WithDownloader("static", dl))
+
+	{ // The second load is served from the cache
+		for i := 0; i < 2; i++ {
+			b, err := loader.Load(context.Background(), "static://test")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+	}
+
+	{ // Not modified since
+		b, err := loader.LoadIf(context.Background(), "static://test", time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+	}
+
+	{ // Downloaded again once expired
+		time.Sleep(60 * time.Millisecond)
+		b, err := loader.Load(context.Background(), "static://test")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, int64(2), atomic.LoadInt64(&dl.count))
+	}
+}
+
+func TestCacheCopy(t *testing.T) {
+	dl := new(staticCounter)
+	loader := New(WithCache(time.Minute), WithDownloader("static", dl))
+
+	// Altering the contents loaded does not alter the cached ones
+	b, err := loader.Load(context.Background(), "static://test")
+	assert.NoError(t, err)
+	copy(b, "jelly")
+
+	b, err = loader.Load(context.Background(), "static://test")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+}
+
+func TestCacheNotModified(t *testing.T) {
+	url := writeTestFile(t, "hello")
+	loader := New(WithCache(time.Nanosecond))
+
+	b, err := loader.Load(context.Background(), url)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// Revalidated, but served from the cache since the file was not modified
+	b, err = loader.Load(context.Background(), url)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestCacheCapacity(t *testing.T) {
+	c := newCache(time.Minute)
+	for i := 0; i < cacheCapacity+10; i++ {
+		c.store(fmt.Sprintf("static://%d", i), &cacheEntry{
+			fetchedAt: time.Unix(int64(i), 0),
+		})
+	}
+
+	assert.Len(t, c.entries, cacheCapacity)
+	assert.NotContains(t, c.entries, "static://0")
+	assert.Contains(t, c.entries, fmt.Sprintf("static://%d", cacheCapacity+9))
+}
+
+// staticCounter always returns the same content and counts the downloads
+type staticCounter struct {
+	count int64
+}
+
+func (d *staticCounter) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	atomic.AddInt64(&d.count, 1)
+	return []byte("hello"), nil
+}
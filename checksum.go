@@ -0,0 +1,39 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// verifyChecksum computes the hash of the payload with the specified algorithm and compares
+// it with the expected digest.
+func verifyChecksum(uri string, data, expected []byte, algo string) error {
+	var h hash.Hash
+	switch strings.ToLower(strings.ReplaceAll(algo, "-", "")) {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("checksum algorithm %s is not supported", algo)
+	}
+
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, uri)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum(t *testing.T) {
+	digest := sha256.Sum256([]byte("hello world"))
+	checksum := WithChecksum(func(uri string) ([]byte, string, bool) {
+		return digest[:], "sha256", uri != "static://unverified"
+	})
+
+	{ // Matching payload
+		loader := New(WithDownloader("static", staticDownloader("hello world")), checksum)
+		b, err := loader.Load(context.Background(), "static://test")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+	}
+
+	{ // Corrupted payload
+		loader := New(WithDownloader("static", staticDownloader("hello w0rld")), checksum)
+		b, err := loader.Load(context.Background(), "static://test")
+		assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		assert.Nil(t, b)
+
+		// Unverified resource
+		b, err = loader.Load(context.Background(), "static://unverified")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello w0rld", string(b))
+	}
+
+	{ // Corrupted payload while watching
+		loader := New(WithDownloader("static", staticDownloader("hello w0rld")), checksum)
+		u := <-loader.Watch(context.Background(), "static://test", time.Minute)
+		assert.True(t, errors.Is(u.Err, ErrChecksumMismatch))
+		assert.Nil(t, u.Data)
+		loader.Unwatch("static://test")
+	}
+}
+
+func TestChecksumAlgorithm(t *testing.T) {
+	loader := New(WithDownloader("static", staticDownloader("hello")), WithChecksum(func(uri string) ([]byte, string, bool) {
+		return nil, "crc32", true
+	}))
+
+	_, err := loader.Load(context.Background(), "static://test")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrChecksumMismatch))
+}
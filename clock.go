@@ -0,0 +1,26 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import "time"
+
+// Clock represents the source of time of the watchers, which can be replaced in order to
+// control their timing deterministically, for example in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock which uses the wall time
+type realClock struct{}
+
+// Now returns the current time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse and then sends the current time on the channel
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
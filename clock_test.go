@@ -0,0 +1,122 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	clock := newFakeClock()
+	dl := new(countingDownloader)
+	loader := New(WithClock(clock), WithDownloader("count", dl))
+	defer loader.Unwatch("count://test")
+
+	loader.Watch(context.Background(), "count://test", time.Hour)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+
+	// Every hour of the fake clock triggers a check, without waiting
+	for i := 2; i <= 5; i++ {
+		clock.WaitForWaiters(t, 1)
+		clock.Advance(time.Hour)
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt64(&dl.count) == int64(i)
+		}, time.Second, time.Millisecond)
+	}
+
+	// Not yet due
+	clock.WaitForWaiters(t, 1)
+	clock.Advance(30 * time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&dl.count))
+}
+
+func TestClockDebounce(t *testing.T) {
+	clock := newFakeClock()
+	loader := New(WithClock(clock), WithDebounce(time.Minute))
+	w := newWatcher(loader, "static://test", time.Hour, func() {})
+	updates, _ := w.subscribe(context.Background(), nil)
+
+	w.emit(Update{Data: []byte("hello")}, false)
+	select {
+	case <-w.flushed():
+		assert.Fail(t, "unexpected flush before the quiet period")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	<-w.flushed()
+	w.flush()
+	assert.Equal(t, "hello", string((<-updates).Data))
+}
+
+// fakeClock is a clock which only moves forward when advanced
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a channel waiting for the clock to reach a deadline
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// WaitForWaiters waits until the number of pending waiters reaches n
+func (c *fakeClock) WaitForWaiters(t *testing.T, n int) {
+	assert.Eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.waiters) >= n
+	}, time.Second, time.Millisecond)
+}
+
+// Advance moves the clock forward and fires the waiters which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// ErrMalformed is returned when the data URI does not conform to RFC 2397
+var ErrMalformed = errors.New("malformed data URI")
+
+// defaultType is the media type of a data URI which does not specify one
+const defaultType = "text/plain;charset=US-ASCII"
+
+// Client represents the client implementation for inline data URIs.
+type Client struct{}
+
+// New creates a new client for data URIs, as defined in RFC 2397.
+func New() *Client {
+	return &Client{}
+}
+
+// DownloadIf decodes the payload of the data URI. Since data URIs are immutable, the
+// payload is always returned regardless of the updatedSince time.
+func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta decodes the payload of the data URI and returns its media type along with it.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	mediaType, b, err := parse(uri)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	return b, resource.Meta{
+		Size:        int64(len(b)),
+		ContentType: mediaType,
+	}, nil
+}
+
+// parse parses the data URI and returns its media type and the decoded payload
+func parse(uri string) (string, []byte, error) {
+	if len(uri) < 5 || !strings.EqualFold(uri[:5], "data:") {
+		return "", nil, fmt.Errorf("%w: missing 'data:' prefix", ErrMalformed)
+	}
+
+	header, payload, ok := strings.Cut(uri[5:], ",")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: missing ',' separator", ErrMalformed)
+	}
+
+	// Percent-encoded payload
+	mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+	if mediaType == "" {
+		mediaType = defaultType
+	}
+	if !isBase64 {
+		b, err := url.PathUnescape(payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return mediaType, []byte(b), nil
+	}
+
+	// Base64-encoded payload, which may also be percent-encoded
+	if unescaped, err := url.PathUnescape(payload); err == nil {
+		payload = unescaped
+	}
+
+	b, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return mediaType, b, nil
+}
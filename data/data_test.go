@@ -0,0 +1,44 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestData(t *testing.T) {
+	client := New()
+	for _, tc := range []struct {
+		uri, expect, mediaType string
+	}{
+		{"data:application/json;base64,eyJoZWxsbyI6IndvcmxkIn0=", `{"hello":"world"}`, "application/json"},
+		{"data:,hello%20world", "hello world", defaultType},
+		{"data:text/plain,hello", "hello", "text/plain"},
+		{"DATA:;base64,aGVsbG8=", "hello", defaultType},
+	} {
+		b, meta, err := client.DownloadMeta(context.Background(), tc.uri, time.Now())
+		assert.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.expect, string(b), tc.uri)
+		assert.Equal(t, tc.mediaType, meta.ContentType, tc.uri)
+		assert.Equal(t, int64(len(tc.expect)), meta.Size, tc.uri)
+	}
+}
+
+func TestDataMalformed(t *testing.T) {
+	client := New()
+	for _, uri := range []string{
+		"data:text/plain;base64",
+		"data:;base64,not base64!",
+		"data:,hello%zz",
+		"file:///hello",
+	} {
+		_, err := client.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.True(t, errors.Is(err, ErrMalformed), uri)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress decompresses the payload if either the content encoding reported by the backend
+// or the extension of the URI indicates a gzip or zstd compressed resource. Since some clients
+// already decompress transparently, the payload is only decompressed if it has a valid header.
+// The decompressed payload is bounded by the limit, if positive, see readAll.
+func decompress(uri string, meta Meta, data []byte, limit int64) ([]byte, error) {
+	switch encodingOf(uri, meta) {
+	case "gzip":
+		if !bytes.HasPrefix(data, gzipMagic) {
+			return data, nil
+		}
+
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+		return readAll(r, limit)
+	case "zstd":
+		if !bytes.HasPrefix(data, zstdMagic) {
+			return data, nil
+		}
+
+		r, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+		return readAll(r, limit)
+	default:
+		return data, nil
+	}
+}
+
+// readAll reads the reader entirely, but returns ErrTooLarge once it yields more than limit
+// bytes, so that a small compressed payload can not inflate without bound. A non-positive
+// limit reads the reader without a bound.
+func readAll(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	switch {
+	case err != nil:
+		return nil, err
+	case int64(len(b)) > limit:
+		return nil, fmt.Errorf("%w: inflated to more than %d bytes", ErrTooLarge, limit)
+	default:
+		return b, nil
+	}
+}
+
+// encodingOf returns the compression encoding of the resource, if any
+func encodingOf(uri string, meta Meta) string {
+	switch strings.ToLower(strings.TrimSpace(meta.ContentEncoding)) {
+	case "gzip", "x-gzip":
+		return "gzip"
+	case "zstd":
+		return "zstd"
+	}
+
+	// Fall back to the extension of the resource
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+
+	switch strings.ToLower(path.Ext(uri)) {
+	case ".gz", ".gzip":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	default:
+		return ""
+	}
+}
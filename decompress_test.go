@@ -0,0 +1,95 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressGzipFile(t *testing.T) {
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	w.Write([]byte(`{"hello":"world"}`))
+	assert.NoError(t, w.Close())
+
+	f := filepath.Join(t.TempDir(), "config.json.gz")
+	assert.NoError(t, os.WriteFile(f, buffer.Bytes(), 0644))
+
+	// Without the option, the raw bytes are returned
+	b, err := New().Load(context.Background(), "file:///"+f)
+	assert.NoError(t, err)
+	assert.Equal(t, buffer.Bytes(), b)
+
+	// With the option, the payload is decompressed
+	b, err = New(WithDecompression()).Load(context.Background(), "file:///"+f)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hello":"world"}`, string(b))
+}
+
+func TestDecompressZstdHTTP(t *testing.T) {
+	enc, _ := zstd.NewWriter(nil)
+	data := enc.EncodeAll([]byte("hello world"), nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	b, err := New(WithDecompression()).Load(context.Background(), ts.URL+"/config")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestDecompressBomb(t *testing.T) {
+	inflated := make([]byte, 16<<20)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(inflated)
+	assert.NoError(t, w.Close())
+
+	enc, _ := zstd.NewWriter(nil)
+	for name, data := range map[string][]byte{
+		"bomb.json.gz":  gz.Bytes(),
+		"bomb.json.zst": enc.EncodeAll(inflated, nil),
+	} {
+		f := filepath.Join(t.TempDir(), name)
+		assert.NoError(t, os.WriteFile(f, data, 0644))
+		assert.Less(t, len(data), 1<<20, name)
+
+		// The compressed payload is small, but it inflates beyond the limit
+		_, err := New(WithDecompression(), WithMaxSize(1<<20)).Load(context.Background(), "file:///"+f)
+		assert.ErrorIs(t, err, ErrTooLarge, name)
+
+		b, err := New(WithDecompression(), WithMaxSize(32<<20)).Load(context.Background(), "file:///"+f)
+		assert.NoError(t, err, name)
+		assert.Len(t, b, len(inflated), name)
+	}
+}
+
+func TestDecompressPassthrough(t *testing.T) {
+	loader := New(WithDecompression())
+
+	// Not a compressed resource
+	b, err := loader.Load(context.Background(), writeTestFile(t, "hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	// Compressed extension, but already decompressed
+	f := filepath.Join(t.TempDir(), "config.json.gz")
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+	b, err = loader.Load(context.Background(), "file:///"+f)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
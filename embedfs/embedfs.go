@@ -0,0 +1,83 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package embedfs
+
+import (
+	"context"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// Client represents the client implementation for a file system embedded in the binary.
+type Client struct {
+	fsys    fs.FS     // The underlying file system, typically an embed.FS
+	modTime time.Time // The synthetic modification time of every file
+}
+
+// New creates a new client which resolves the embed:// URIs against the file system, for
+// example an embed.FS. Since embedded files never change, they are considered as modified
+// at the time the client is created.
+func New(fsys fs.FS) *Client {
+	return &Client{
+		fsys:    fsys,
+		modTime: time.Now(),
+	}
+}
+
+// DownloadIf reads a file only if the updatedSince time is older than the creation time
+// of the client.
+func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta reads a file only if the updatedSince time is older than the creation time
+// of the client and returns the metadata of the file along with its contents.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	name, err := parse(uri)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// Make sure the file exists, even if it's not modified
+	if _, err := fs.Stat(c.fsys, name); err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	if !isModified(c.modTime, updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	b, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	return b, resource.Meta{
+		LastModified: c.modTime,
+		Size:         int64(len(b)),
+		ContentType:  mime.TypeByExtension(path.Ext(name)),
+	}, nil
+}
+
+// parse returns the name of the file in the file system, both embed://dir/file and
+// embed:///dir/file forms are accepted.
+func parse(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(path.Join(u.Host, u.Path), "/"), nil
+}
+
+func isModified(updatedAt, updatedSince time.Time) bool {
+	return updatedAt.After(updatedSince)
+}
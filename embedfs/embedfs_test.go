@@ -0,0 +1,41 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package embedfs
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+func TestEmbed(t *testing.T) {
+	client := New(testdata)
+
+	for _, uri := range []string{"embed://testdata/hello.txt", "embed:///testdata/hello.txt"} {
+		b, meta, err := client.DownloadMeta(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+		assert.Equal(t, int64(11), meta.Size)
+		assert.Contains(t, meta.ContentType, "text/plain")
+	}
+
+	{ // Never modified afterwards
+		b, err := client.DownloadIf(context.Background(), "embed://testdata/hello.txt", time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Missing file
+		_, err := client.DownloadIf(context.Background(), "embed://testdata/missing.txt", time.Now())
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	}
+}
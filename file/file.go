@@ -5,52 +5,521 @@ package file
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"mime"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
+
+	"github.com/kelindar/loader/resource"
 )
 
+// ErrOutsideRoot is returned when a path escapes the root directory of the client
+var ErrOutsideRoot = errors.New("path is outside of the root directory")
+
 // Client represents the client implementation.
 type Client struct {
+	hashing bool     // Whether the freshness is decided by the content hash
+	root    string   // The root directory which confines the reads, if any
+	open    openFunc // Opens a file for reading
+	symlink bool     // Whether a change of the symlink target counts as a modification
+}
+
+// openFunc opens a file for reading
+type openFunc func(name string) (io.ReadCloser, error)
+
+// New creates a new client for file system reads.
+func New(options ...func(*Client)) *Client {
+	c := &Client{open: openFile}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-// New creates a new client for HTTP downloads.
-func New() *Client {
-	return &Client{}
+// NewWithRoot creates a new client for file system reads which are confined to the root
+// directory. The paths of the URIs are relative to the root (e.g. 'file:///app.json' reads
+// the 'app.json' file in the root) and any path escaping the root returns ErrOutsideRoot. The
+// symlinks are resolved as well, so a link pointing outside of the root can not be followed.
+func NewWithRoot(root string, options ...func(*Client)) *Client {
+	c := New(options...)
+	c.root = filepath.Clean(root)
+	return c
+}
+
+// WithContentHash makes DownloadIf compare the hash of the file contents with the one last seen
+// by the caller (e.g. a watcher), carried by the context with resource.LastSeen, instead of
+// relying on its modification time. This is slower, since the file must be read on every check,
+// but correct on file systems where modification times are unreliable.
+func WithContentHash() func(*Client) {
+	return func(c *Client) {
+		c.hashing = true
+	}
+}
+
+// WithSymlinkTracking makes DownloadIf report the file as modified whenever the symbolic links
+// of its path (e.g. a 'current' link to the directory of the latest release) are pointed to a
+// different target than the one last seen by the caller (e.g. a watcher), carried by the context
+// with resource.LastSeen, even if the modification time of the new target is older.
+func WithSymlinkTracking() func(*Client) {
+	return func(c *Client) {
+		c.symlink = true
+	}
 }
 
 // DownloadIf downloads a file only if the updatedSince time is older than the resource
 // timestamp itself.
 func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
-	u, err := parse(uri)
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the file along with its contents.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	u, err := c.parse(uri)
 	if err != nil {
-		return nil, err
+		return nil, resource.Meta{}, err
 	}
 
 	// Get the file information
-	fi, err := os.Stat(u.Path)
+	fi, err := await(ctx, func() (os.FileInfo, error) {
+		return os.Stat(u.Path)
+	})
 	if err != nil {
-		return nil, err
+		return nil, resource.Meta{}, err
+	}
+
+	// Resolve the symbolic links of the path, if tracked
+	target, retargeted, err := c.resolveLinks(ctx, u.Path)
+	if err != nil {
+		return nil, resource.Meta{}, err
 	}
 
 	// No updates have happened since the provided date
-	if !isModified(fi.ModTime(), updatedSince) {
-		return nil, nil
+	if !c.hashing && !retargeted && !isModified(fi.ModTime(), updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	// Make sure the file is not too large to be read
+	if err := resource.CheckSize(ctx, fi.Size()); err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	b, err := c.readFile(ctx, u.Path)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// The contents are identical to the ones seen last time
+	meta := metaOf(u.Path, fi)
+	meta.Target = target
+	if c.hashing {
+		meta.Checksum = checksumOf(b)
+		if !isChanged(ctx, meta.Checksum, updatedSince) && !retargeted {
+			return nil, resource.Meta{}, nil
+		}
+	}
+
+	return b, meta, nil
+}
+
+// resolveLinks returns the path with its symbolic links resolved, and whether the target differs
+// from the one last seen by the caller, if the symbolic links are tracked.
+func (c *Client) resolveLinks(ctx context.Context, path string) (string, bool, error) {
+	if !c.symlink {
+		return "", false, nil
+	}
+
+	target, err := await(ctx, func() (string, error) {
+		return filepath.EvalSymlinks(path)
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	last := resource.LastSeenOf(ctx)
+	return target, last.Target != "" && last.Target != target, nil
+}
+
+// isChanged returns whether the hash of the contents differs from the one last seen by the
+// caller. Unconditional downloads, or the callers which have not seen any hash yet, are always
+// considered as changed.
+func isChanged(ctx context.Context, checksum string, updatedSince time.Time) bool {
+	last := resource.LastSeenOf(ctx)
+	return updatedSince.Unix() <= 0 || last.Checksum != checksum
+}
+
+// checksumOf returns the hex-encoded SHA-256 hash of the contents
+func checksumOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Exists checks whether the file exists.
+func (c *Client) Exists(ctx context.Context, uri string) (bool, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return false, err
 	}
 
-	return c.Download(uri)
+	_, err = await(ctx, func() (os.FileInfo, error) {
+		return os.Stat(u.Path)
+	})
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// Stat returns the metadata of the file without reading it.
+func (c *Client) Stat(ctx context.Context, uri string) (resource.Meta, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return resource.Meta{}, err
+	}
+
+	fi, err := await(ctx, func() (os.FileInfo, error) {
+		return os.Stat(u.Path)
+	})
+	if err != nil {
+		return resource.Meta{}, err
+	}
+
+	return metaOf(u.Path, fi), nil
+}
+
+// Upload writes the data into the file, creating its directory if required.
+func (c *Client) Upload(ctx context.Context, uri string, data []byte) error {
+	u, err := c.parse(uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = await(ctx, func() (struct{}, error) {
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0755); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, os.WriteFile(u.Path, data, 0644)
+	})
+	return err
 }
 
 // Download simply downloads a file using an HTTP GET request.
 func (c *Client) Download(uri string) ([]byte, error) {
-	u, err := parse(uri)
+	u, err := c.parse(uri)
 	if err != nil {
 		return nil, err
 	}
 
 	// Read the file into a buffer
-	return ioutil.ReadFile(u.Path)
+	return c.readFile(context.Background(), u.Path)
+}
+
+// readFile reads the file incrementally and returns early with the context error if the
+// context is cancelled, even if the read itself is blocked (e.g. on a hung network mount).
+func (c *Client) readFile(ctx context.Context, path string) ([]byte, error) {
+	return await(ctx, func() ([]byte, error) {
+		f, err := c.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+		return ioutil.ReadAll(&contextReader{ctx: ctx, r: f})
+	})
+}
+
+// Stream opens the file for reading. Both opening and reading the file stop once the context
+// is cancelled.
+func (c *Client) Stream(ctx context.Context, uri string) (io.ReadCloser, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := openContext(ctx, u.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contextReadCloser{
+		contextReader: contextReader{ctx: ctx, r: f},
+		Closer:        f,
+	}, nil
+}
+
+// DownloadTo copies the file into the writer and returns the number of bytes written.
+func (c *Client) DownloadTo(ctx context.Context, uri string, w io.Writer) (int64, error) {
+	r, err := c.Stream(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// Fingerprint returns a fingerprint of the file derived from its size and modification time.
+func (c *Client) Fingerprint(ctx context.Context, uri string) (string, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := await(ctx, func() (os.FileInfo, error) {
+		return os.Stat(u.Path)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return metaOf(u.Path, fi).ETag, nil
+}
+
+// DownloadHead reads the first n bytes of a file.
+func (c *Client) DownloadHead(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return c.readAt(ctx, uri, func(f *os.File) (int64, int64, error) {
+		return 0, n, nil
+	})
+}
+
+// DownloadTail reads the last n bytes of a file by seeking to the end of it.
+func (c *Client) DownloadTail(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return c.readAt(ctx, uri, func(f *os.File) (int64, int64, error) {
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, 0, err
+		}
+		return max(fi.Size()-n, 0), n, nil
+	})
+}
+
+// DownloadRange reads length bytes of a file starting at the offset.
+func (c *Client) DownloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	return c.readAt(ctx, uri, func(f *os.File) (int64, int64, error) {
+		return offset, length, nil
+	})
+}
+
+// readAt reads the part of the file at the offset and of the length returned by the function,
+// and returns early with the context error if the context is cancelled, like readFile.
+func (c *Client) readAt(ctx context.Context, uri string, partOf func(*os.File) (int64, int64, error)) ([]byte, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return await(ctx, func() ([]byte, error) {
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+		offset, length, err := partOf(f)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(&contextReader{ctx: ctx, r: io.LimitReader(f, length)})
+	})
+}
+
+// List returns the URIs of the files matching the path. If the path is a glob pattern, the
+// matching files are returned, if it is a directory, the files it contains are returned.
+func (c *Client) List(ctx context.Context, uri string) ([]string, error) {
+	u, err := c.parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	switch fi, err := os.Stat(u.Path); {
+	case strings.ContainsAny(u.Path, "*?["):
+		if paths, err = filepath.Glob(u.Path); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !fi.IsDir():
+		paths = []string{u.Path}
+	default:
+		entries, err := os.ReadDir(u.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(u.Path, entry.Name()))
+		}
+	}
+
+	// Only keep the files and convert them back to URIs
+	var keys []string
+	for _, path := range paths {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			if c.root != "" {
+				path, _ = filepath.Rel(c.root, path)
+			}
+
+			keys = append(keys, fmt.Sprintf("%s:///%s", u.Scheme, filepath.ToSlash(path)))
+		}
+	}
+	return keys, nil
+}
+
+// parse parses the URI and resolves its path against the root directory, if any
+func (c *Client) parse(uri string) (*url.URL, error) {
+	u, err := parse(uri)
+	if err != nil || c.root == "" {
+		return u, err
+	}
+
+	// Make sure the path does not escape the root
+	path := filepath.Join(c.root, filepath.FromSlash(u.Path))
+	if !isWithin(c.root, path) {
+		return nil, fmt.Errorf("%w: %s", ErrOutsideRoot, u.Path)
+	}
+
+	// Make sure the symlinks along the path do not lead outside of the root either
+	root, err := realPath(c.root)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := realPath(path)
+	switch {
+	case err != nil:
+		return nil, err
+	case !isWithin(root, target):
+		return nil, fmt.Errorf("%w: %s", ErrOutsideRoot, u.Path)
+	}
+
+	u.Path = path
+	return u, nil
+}
+
+// isWithin returns whether the path is the root itself or lies under it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath resolves the symlinks of the path. If the path does not exist yet (e.g. before an
+// upload), the symlinks of its closest existing parent are resolved instead, while a dangling
+// symlink is an error since its target can not be known.
+func realPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return resolved, err
+	}
+
+	if info, lerr := os.Lstat(path); lerr == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+
+	dir, err := realPath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// openFile opens the file on the local file system
+func openFile(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// await runs the file system call on a separate goroutine and returns early if the context
+// is cancelled, since such calls can not be interrupted otherwise.
+func await[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	case r := <-done:
+		return r.value, r.err
+	}
+}
+
+// openContext opens the file and returns early if the context is cancelled in the meantime, in
+// which case the file is closed as soon as it is eventually opened, so that it does not leak.
+func openContext(ctx context.Context, path string) (*os.File, error) {
+	type result struct {
+		file *os.File
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		f, err := os.Open(path)
+		done <- result{f, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.file != nil {
+				r.file.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.file, r.err
+	}
+}
+
+// contextReadCloser is a contextReader which also closes the underlying file
+type contextReadCloser struct {
+	contextReader
+	io.Closer
+}
+
+// contextReader is a reader which stops reading once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the underlying reader, unless the context is cancelled
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
 
 func parse(uri string) (*url.URL, error) {
@@ -59,13 +528,37 @@ func parse(uri string) (*url.URL, error) {
 		return nil, err
 	}
 
-	// Remove the first slash
-	if len(u.Path) > 0 && u.Path[0] == '/' {
-		u.Path = u.Path[1:]
-	}
+	u.Path = pathOf(u, runtime.GOOS)
 	return u, nil
 }
 
+// pathOf returns the path of the file for the operating system, without the first slash. On
+// Windows, both the drive letters (e.g. 'file:///C:/app.json') and the UNC shares (e.g.
+// 'file://host/share/app.json') are supported.
+func pathOf(u *url.URL, goos string) string {
+	path := strings.TrimPrefix(u.Path, "/")
+	if goos != "windows" {
+		return path
+	}
+
+	path = strings.ReplaceAll(path, "/", `\`)
+	if u.Host != "" && u.Host != "localhost" {
+		return `\\` + u.Host + `\` + path
+	}
+	return path
+}
+
+// metaOf returns the metadata of the file. Since the file system has no notion of
+// an entity tag, a weak one is derived from the modification time and the size.
+func metaOf(path string, fi os.FileInfo) resource.Meta {
+	return resource.Meta{
+		LastModified: fi.ModTime(),
+		Size:         fi.Size(),
+		ETag:         fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+	}
+}
+
 func isModified(updatedAt, updatedSince time.Time) bool {
-	return updatedAt.UTC().Unix() > updatedSince.UTC().Unix()
+	return updatedAt.After(updatedSince)
 }
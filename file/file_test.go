@@ -4,11 +4,20 @@
 package file
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/kelindar/loader/resource"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +40,456 @@ func TestFile(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestFileSubSecond(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	url := "file:///" + f
+	base := time.Now().Truncate(time.Second).Add(-time.Hour)
+	write := func(content string, mtime time.Time) {
+		assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+		assert.NoError(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	client := New()
+	write("hello", base.Add(100*time.Millisecond))
+	since := base.Add(200 * time.Millisecond)
+
+	{ // Written before the last check
+		b, err := client.DownloadIf(context.Background(), url, since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Written again within the same second
+		write("world", base.Add(300*time.Millisecond))
+		b, err := client.DownloadIf(context.Background(), url, since)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+	}
+}
+
+func TestFileContentHash(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	url := "file:///" + f
+	mtime := time.Now().Add(-time.Hour)
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+		assert.NoError(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	write("hello")
+	client := New(WithContentHash())
+	since := time.Now()
+
+	// Unknown hash, considered as changed
+	b, last, err := client.DownloadMeta(context.Background(), url, since)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Len(t, last.Checksum, 64)
+	ctx := resource.LastSeen(context.Background(), last)
+
+	{ // Same hash, not changed
+		b, err := client.DownloadIf(ctx, url, since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Different contents but the same modification time, even once another caller has seen them
+		write("world")
+		b, err := client.DownloadIf(context.Background(), url, since)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+
+		b, err = client.DownloadIf(ctx, url, since)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+	}
+
+	{ // Without hashing, the change is missed
+		write("again")
+		b, err := New().DownloadIf(context.Background(), url, since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+}
+
+func TestFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	for _, release := range []string{"v1", "v2"} {
+		f := filepath.Join(dir, "releases", release, "config.json")
+		assert.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		assert.NoError(t, os.WriteFile(f, []byte(release), 0644))
+		assert.NoError(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	// Points the 'current' link to a release, atomically
+	current := filepath.Join(dir, "current")
+	link := func(release string) {
+		tmp := current + ".tmp"
+		if err := os.Symlink(filepath.Join(dir, "releases", release), tmp); err != nil {
+			t.Skip("symbolic links are not supported")
+		}
+		assert.NoError(t, os.Rename(tmp, current))
+	}
+
+	link("v1")
+	url := "file:///" + filepath.Join(current, "config.json")
+	client := New(WithSymlinkTracking())
+	since := time.Now()
+
+	// Unconditional download
+	b, last, err := client.DownloadMeta(context.Background(), url, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(b))
+	assert.Equal(t, "v1", filepath.Base(filepath.Dir(last.Target)))
+	ctx := resource.LastSeen(context.Background(), last)
+
+	{ // Same target, not modified
+		b, err := client.DownloadIf(ctx, url, since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Different target, modified even though the file is older and another caller has seen it
+		link("v2")
+		b, err := client.DownloadIf(context.Background(), url, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+
+		b, err = client.DownloadIf(ctx, url, since)
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+	}
+
+	{ // Without tracking, the flip is missed
+		link("v1")
+		b, err := New().DownloadIf(context.Background(), url, since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+}
+
+func TestFileMeta(t *testing.T) {
+	f, _ := filepath.Abs("file.go")
+	url := "file:///" + f
+	fi, _ := os.Stat(f)
+
+	client := New()
+	b, meta, err := client.DownloadMeta(context.Background(), url, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.NotNil(t, b)
+	assert.Equal(t, fi.ModTime(), meta.LastModified)
+	assert.Equal(t, int64(len(b)), meta.Size)
+	assert.NotEmpty(t, meta.ETag)
+}
+
+func TestFileStat(t *testing.T) {
+	f, _ := filepath.Abs("file.go")
+	fi, err := os.Stat(f)
+	assert.NoError(t, err)
+
+	meta, err := New().Stat(context.Background(), "file:///"+f)
+	assert.NoError(t, err)
+	assert.Equal(t, fi.Size(), meta.Size)
+	assert.Equal(t, fi.ModTime(), meta.LastModified)
+	assert.NotEmpty(t, meta.ETag)
+
+	_, err = New().Stat(context.Background(), "file:///missing.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestFileUpload(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "a", "b", "hello.txt")
+	assert.NoError(t, New().Upload(context.Background(), "file:///"+f, []byte("hello")))
+
+	b, err := os.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestFileStream(t *testing.T) {
+	f, _ := filepath.Abs("file.go")
+	expect, _ := os.ReadFile(f)
+
+	r, err := New().Stream(context.Background(), "file:///"+f)
+	assert.NoError(t, err)
+
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, expect, b)
+}
+
+func TestFileFingerprint(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello"), 0644))
+	url := "file:///" + f
+
+	client := New()
+	fp1, err := client.Fingerprint(context.Background(), url)
+	assert.NoError(t, err)
+	fp2, err := client.Fingerprint(context.Background(), url)
+	assert.NoError(t, err)
+	assert.Equal(t, fp1, fp2)
+
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+	fp3, err := client.Fingerprint(context.Background(), url)
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp1, fp3)
+}
+
+func TestFileHeadTail(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+	url := "file:///" + f
+
+	client := New()
+	for _, tc := range []struct {
+		read   func(context.Context, string, int64) ([]byte, error)
+		n      int64
+		expect string
+	}{
+		{read: client.DownloadHead, n: 5, expect: "hello"},
+		{read: client.DownloadHead, n: 100, expect: "hello world"},
+		{read: client.DownloadTail, n: 5, expect: "world"},
+		{read: client.DownloadTail, n: 100, expect: "hello world"},
+	} {
+		b, err := tc.read(context.Background(), url, tc.n)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, string(b))
+	}
+}
+
+func TestFileList(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	for _, name := range []string{"a.json", "b.json", "c.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	client := New()
+	for _, tc := range []struct {
+		path   string
+		expect []string
+	}{
+		{path: dir, expect: []string{"a.json", "b.json", "c.txt"}},
+		{path: filepath.Join(dir, "*.json"), expect: []string{"a.json", "b.json"}},
+		{path: filepath.Join(dir, "c.txt"), expect: []string{"c.txt"}},
+		{path: filepath.Join(dir, "*.csv"), expect: nil},
+	} {
+		keys, err := client.List(context.Background(), "file:///"+tc.path)
+		assert.NoError(t, err)
+
+		var expect []string
+		for _, name := range tc.expect {
+			expect = append(expect, "file:///"+filepath.ToSlash(filepath.Join(dir, name)))
+		}
+		assert.Equal(t, expect, keys)
+	}
+
+	_, err := client.List(context.Background(), "file:///"+filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestFileRoot(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "conf"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "conf", "app.json"), []byte("hello"), 0644))
+	client := NewWithRoot(root)
+
+	for _, uri := range []string{"file:///conf/app.json", "file:///conf/../conf/app.json"} {
+		b, err := client.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err, uri)
+		assert.Equal(t, "hello", string(b), uri)
+	}
+
+	for _, uri := range []string{"file:///../etc/passwd", "file:///conf/../../etc/passwd", "file:///.."} {
+		_, err := client.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.True(t, errors.Is(err, ErrOutsideRoot), uri)
+	}
+
+	keys, err := client.List(context.Background(), "file:///conf")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file:///conf/app.json"}, keys)
+}
+
+func TestFileCancelOpen(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "fifo")
+	if err := exec.Command("mkfifo", fifo).Run(); err != nil {
+		t.Skip("named pipes are not supported")
+	}
+
+	// Opening a named pipe blocks until a writer opens it, just like a hung network mount
+	client := New()
+	for name, fn := range map[string]func(context.Context) error{
+		"Stream": func(ctx context.Context) error {
+			_, err := client.Stream(ctx, "file:///"+fifo)
+			return err
+		},
+		"DownloadHead": func(ctx context.Context) error {
+			_, err := client.DownloadHead(ctx, "file:///"+fifo, 5)
+			return err
+		},
+		"DownloadTail": func(ctx context.Context) error {
+			_, err := client.DownloadTail(ctx, "file:///"+fifo, 5)
+			return err
+		},
+		"DownloadRange": func(ctx context.Context) error {
+			_, err := client.DownloadRange(ctx, "file:///"+fifo, 1, 5)
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		assert.Equal(t, context.DeadlineExceeded, fn(ctx), name)
+		assert.Less(t, time.Since(start), time.Second, name)
+		cancel()
+	}
+
+	// Unblock the pending opens
+	if f, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
+
+func TestFileRootSymlink(t *testing.T) {
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "app.json"), []byte("hello"), 0644))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(root, "dangling.txt")))
+	assert.NoError(t, os.Symlink(filepath.Join(root, "app.json"), filepath.Join(root, "link.json")))
+	client := NewWithRoot(root)
+
+	// The links within the root are followed
+	b, err := client.DownloadIf(context.Background(), "file:///link.json", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// The links leading outside of the root are not
+	for _, uri := range []string{"file:///secret.txt", "file:///escape/secret.txt", "file:///escape/new.txt"} {
+		_, err := client.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.ErrorIs(t, err, ErrOutsideRoot, uri)
+		assert.ErrorIs(t, client.Upload(context.Background(), uri, []byte("hacked")), ErrOutsideRoot, uri)
+	}
+
+	// Nor is a dangling link written through
+	assert.Error(t, client.Upload(context.Background(), "file:///dangling.txt", []byte("hacked")))
+	_, err = os.Stat(filepath.Join(outside, "missing.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// New files can still be uploaded within the root
+	assert.NoError(t, client.Upload(context.Background(), "file:///conf/new.json", []byte("new")))
+	b, err = os.ReadFile(filepath.Join(root, "conf", "new.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+
+	b, err = os.ReadFile(filepath.Join(outside, "secret.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+}
+
+func TestFileCancel(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+
+	client := New()
+	client.open = func(name string) (io.ReadCloser, error) {
+		return io.NopCloser(&slowReader{delay: 10 * time.Millisecond}), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	b, err := client.DownloadIf(ctx, "file:///"+f, time.Unix(0, 0))
+	assert.Nil(t, b)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFileRange(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+	url := "file:///" + f
+
+	client := New()
+	for _, tc := range []struct {
+		offset, length int64
+		expect         string
+	}{
+		{offset: 3, length: 5, expect: "lo wo"},
+		{offset: 0, length: 5, expect: "hello"},
+		{offset: 6, length: 100, expect: "world"},
+		{offset: 100, length: 5, expect: ""},
+	} {
+		b, err := client.DownloadRange(context.Background(), url, tc.offset, tc.length)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, string(b))
+	}
+}
+
+// slowReader is an endless reader which returns a byte at a time, after a delay
+type slowReader struct {
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestFileExists(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello"), 0644))
+
+	client := New()
+	for path, expect := range map[string]bool{
+		f: true,
+		filepath.Join(filepath.Dir(f), "missing.txt"): false,
+	} {
+		ok, err := client.Exists(context.Background(), "file:///"+path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expect, ok, path)
+	}
+
+	_, err := NewWithRoot(t.TempDir()).Exists(context.Background(), "file:///../test.txt")
+	assert.True(t, errors.Is(err, ErrOutsideRoot))
+}
+
+func TestFileDownloadTo(t *testing.T) {
+	f, _ := filepath.Abs("file.go")
+	expect, _ := os.ReadFile(f)
+
+	var buffer bytes.Buffer
+	n, err := New().DownloadTo(context.Background(), "file:///"+f, &buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(expect)), n)
+	assert.Equal(t, expect, buffer.Bytes())
+
+	_, err = New().DownloadTo(context.Background(), "file:///"+f+".missing", &buffer)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestPathOf(t *testing.T) {
+	for _, tc := range []struct {
+		uri, goos, expect string
+	}{
+		{uri: "file:///C:/config/app.json", goos: "windows", expect: `C:\config\app.json`},
+		{uri: "file:///c:/app.json", goos: "windows", expect: `c:\app.json`},
+		{uri: "file://localhost/C:/app.json", goos: "windows", expect: `C:\app.json`},
+		{uri: "file://server/share/config/app.json", goos: "windows", expect: `\\server\share\config\app.json`},
+		{uri: "file:////etc/app.json", goos: "linux", expect: "/etc/app.json"},
+		{uri: "file:///config/app.json", goos: "linux", expect: "config/app.json"},
+		{uri: "file://server/etc/app.json", goos: "darwin", expect: "etc/app.json"},
+	} {
+		u, err := url.ParseRequestURI(tc.uri)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, pathOf(u, tc.goos), tc.uri)
+	}
+}
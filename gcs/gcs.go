@@ -6,80 +6,306 @@ package gcs
 import (
 	"context"
 	"errors"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/kelindar/loader/resource"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
-const scope = storage.ScopeReadOnly
-
 // ErrNoSuchKey is returned when the requested file does not exist
-var ErrNoSuchKey = errors.New("key does not exist")
+var ErrNoSuchKey error = resource.NotFoundError("key does not exist")
 
 // Client represents the client implementation for the Google Cloud Storage downloader.
 type Client struct {
-	client *storage.Client
+	client     *storage.Client       // The underlying storage client
+	options    []option.ClientOption // The options used to create the storage client
+	maxListing int                   // The maximum number of objects to scan under a prefix
+	keyFilter  func(string) bool     // The filter of the keys which can be selected as the latest
+	selector   Selector              // The strategy selecting the object under a prefix, if any
+	exact      bool                  // Whether the keys are always matched exactly, never as a prefix
+	project    string                // The project billed for the requests, if any
+	trigger    string                // The custom metadata key which marks the changes, if any
+	writable   bool                  // Whether the scope of the credentials allows the uploads
 }
 
-// New creates a new client for Google Cloud Storage.
-func New() (*Client, error) {
-	var opts []option.ClientOption
-	if creds, err := loadCredentials(); err == nil {
-		opts = append(opts, option.WithCredentials(creds))
-	} else {
-		opts = append(opts, option.WithScopes(scope))
-		opts = append(opts, option.WithoutAuthentication())
+// New creates a new client for Google Cloud Storage. The credentials are read from the
+// 'GOOGLE_APPLICATION_CREDENTIALS_RAW' environment variable or the default credentials chain,
+// and if none are found, the requests are not authenticated.
+func New(options ...func(*Client)) (*Client, error) {
+	return newClient(func(scope string) ([]option.ClientOption, error) {
+		if creds, err := loadCredentials(scope); err == nil {
+			return []option.ClientOption{option.WithCredentials(creds)}, nil
+		}
+
+		return []option.ClientOption{
+			option.WithScopes(scope),
+			option.WithoutAuthentication(),
+		}, nil
+	}, options...)
+}
+
+// NewWithCredentials creates a new client for Google Cloud Storage which authenticates with the
+// JSON key (e.g. of a service account), for example when it is fetched from a secret manager.
+func NewWithCredentials(jsonKey []byte, options ...func(*Client)) (*Client, error) {
+	return newClient(func(scope string) ([]option.ClientOption, error) {
+		creds, err := google.CredentialsFromJSON(context.Background(), jsonKey, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		return []option.ClientOption{option.WithCredentials(creds)}, nil
+	}, options...)
+}
+
+// NewWithTokenSource creates a new client for Google Cloud Storage which authenticates with
+// the tokens of the token source.
+func NewWithTokenSource(ts oauth2.TokenSource, options ...func(*Client)) (*Client, error) {
+	return newClient(func(string) ([]option.ClientOption, error) {
+		return []option.ClientOption{option.WithTokenSource(ts)}, nil
+	}, options...)
+}
+
+// newClient creates a new client for Google Cloud Storage with the authentication options for
+// the scope required by the options
+func newClient(auth func(scope string) ([]option.ClientOption, error), options ...func(*Client)) (*Client, error) {
+	s := new(Client)
+	for _, option := range options {
+		option(s)
+	}
+
+	opts, err := auth(s.scope())
+	if err != nil {
+		return nil, err
 	}
 
 	if os.Getenv("STORAGE_EMULATOR_ENDPOINT") != "" {
 		opts = append(opts, option.WithEndpoint(os.Getenv("STORAGE_EMULATOR_ENDPOINT")))
 	}
 
-	c, err := storage.NewClient(context.Background(), opts...)
+	c, err := storage.NewClient(context.Background(), append(opts, s.options...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		client: c,
-	}, nil
+	s.client = c
+	return s, nil
+}
+
+// WithWriteAccess requests the read-write scope for the credentials, which is required to
+// upload the objects. By default, only the read-only scope is requested.
+func WithWriteAccess() func(*Client) {
+	return func(s *Client) {
+		s.writable = true
+	}
+}
+
+// scope returns the OAuth scope of the credentials, read-only unless the uploads are enabled
+func (s *Client) scope() string {
+	if s.writable {
+		return storage.ScopeReadWrite
+	}
+	return storage.ScopeReadOnly
+}
+
+// WithHTTPClient sets the HTTP client to use for all of the requests, for example to route
+// them through a proxy or an instrumented transport.
+func WithHTTPClient(client *http.Client) func(*Client) {
+	return func(s *Client) {
+		s.options = append(s.options, option.WithHTTPClient(client))
+	}
+}
+
+// WithUserProject sets the project which is billed for the requests, as required to read from
+// the requester-pays buckets.
+func WithUserProject(projectID string) func(*Client) {
+	return func(s *Client) {
+		s.project = projectID
+	}
 }
 
 // DownloadIf downloads a file only if the updatedSince time is older than the resource
 // timestamp itself.
 func (s *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
-	bucket, prefix, err := parseURI(uri)
+	b, _, err := s.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the object along with its contents. If the key
+// ends with a '/' or a '*', the latest object under that prefix is downloaded, otherwise the
+// object with the exact key is downloaded without listing the bucket.
+func (s *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	bucket, attrs, err := s.resolve(ctx, uri)
 	if err != nil {
-		return nil, err
+		return nil, resource.Meta{}, convertError(err)
 	}
 
-	// Get the latest key
-	key, updatedAt, err := s.getLatestKey(ctx, bucket, prefix)
+	b, meta, err := s.downloadIf(ctx, bucket, attrs, updatedSince)
+	return b, meta, convertError(err)
+}
+
+// DownloadExactIf downloads the object with the exact key only if the updatedSince time is
+// older than the object timestamp itself. The attributes of the object are read directly, so
+// unlike a prefix, no listing of the bucket is required.
+func (s *Client) DownloadExactIf(ctx context.Context, bucket, key string, updatedSince time.Time) ([]byte, error) {
+	attrs, err := s.attrsOf(ctx, bucket, key)
 	if err != nil {
 		return nil, err
 	}
 
-	// If the latest key is older than the time, skip
-	if !isModified(updatedAt, updatedSince) {
-		return nil, nil
+	b, _, err := s.downloadIf(ctx, bucket, attrs, updatedSince)
+	return b, err
+}
+
+// downloadIf downloads the object only if it was modified since the specified time
+func (s *Client) downloadIf(ctx context.Context, bucket string, attrs *storage.ObjectAttrs, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	if !s.isModifiedSince(ctx, attrs, updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	if err := resource.CheckSize(ctx, attrs.Size); err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// Read the generation which was resolved, in case a newer one is written in the meantime
+	object := s.bucket(bucket).Object(attrs.Name)
+	if attrs.Generation > 0 {
+		object = object.Generation(attrs.Generation)
+	}
+
+	b, err := s.download(ctx, object)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	meta := metaOf(attrs)
+	meta.Size = int64(len(b))
+	meta.Marker, _ = s.markerOf(attrs)
+	return b, meta, nil
+}
+
+// WithMetadataTrigger sets the custom metadata key (e.g. 'x-goog-meta-version' or 'version')
+// whose value marks the changes of the objects, for producers which rewrite the objects without
+// meaningful changes. An object carrying the key is only downloaded again once the value differs
+// from the one last seen by the caller (e.g. a watcher), carried by the context with
+// resource.LastSeen, regardless of its modification time. The objects without the key, or the
+// callers which have not seen a value yet, fall back to the modification time.
+func WithMetadataTrigger(key string) func(*Client) {
+	return func(s *Client) {
+		s.trigger = strings.TrimPrefix(strings.ToLower(key), "x-goog-meta-")
+	}
+}
+
+// isModifiedSince returns whether the object was modified since the time, according to the
+// metadata trigger if the object carries it and the caller has seen a value before.
+func (s *Client) isModifiedSince(ctx context.Context, attrs *storage.ObjectAttrs, updatedSince time.Time) bool {
+	last := resource.LastSeenOf(ctx)
+	if marker, ok := s.markerOf(attrs); ok && last.Marker != "" && updatedSince.Unix() > 0 {
+		return last.Marker != marker
 	}
 
-	// Download and return the updatedAt time
-	return s.Download(ctx, bucket, key)
+	return isModified(attrs.Updated, updatedSince)
+}
+
+// markerOf returns the value of the metadata trigger of the object, if any
+func (s *Client) markerOf(attrs *storage.ObjectAttrs) (string, bool) {
+	if s.trigger == "" {
+		return "", false
+	}
+
+	for k, v := range attrs.Metadata {
+		if strings.EqualFold(k, s.trigger) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Upload uploads the data as the object with the exact key of the URI. This requires the client
+// to be created with WithWriteAccess, since only the read-only scope is requested by default.
+func (s *Client) Upload(ctx context.Context, uri string, data []byte) error {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	w := s.bucket(bucket).Object(key).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Stat returns the metadata of the object without downloading it, which is the latest object
+// under the prefix if the key ends with a '/' or a '*'.
+func (s *Client) Stat(ctx context.Context, uri string) (resource.Meta, error) {
+	_, attrs, err := s.resolve(ctx, uri)
+	if err != nil {
+		return resource.Meta{}, err
+	}
+
+	return metaOf(attrs), nil
+}
+
+// metaOf returns the metadata of the object from its attributes
+func metaOf(attrs *storage.ObjectAttrs) resource.Meta {
+	return resource.Meta{
+		LastModified:    attrs.Updated,
+		Size:            attrs.Size,
+		ETag:            attrs.Etag,
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+		Generation:      attrs.Generation,
+	}
+}
+
+// DownloadIfNewer downloads the most recently modified object under the prefix, but only if
+// it was modified after the 'since' time. It returns the modification time of that object
+// regardless, and a nil payload if it was not modified.
+func (s *Client) DownloadIfNewer(ctx context.Context, bucket, prefix string, since time.Time) ([]byte, time.Time, error) {
+	attrs, err := s.getLatestKey(ctx, bucket, prefix)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !isModified(attrs.Updated, since) {
+		return nil, attrs.Updated, nil
+	}
+
+	b, err := s.Download(ctx, bucket, attrs.Name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return b, attrs.Updated, nil
 }
 
 // Download loads a specified object from the bucket
 func (s *Client) Download(ctx context.Context, bucket, key string) ([]byte, error) {
-	handle := s.client.Bucket(bucket)
-	object := handle.Object(key)
+	return s.download(ctx, s.bucket(bucket).Object(key))
+}
+
+// DownloadGeneration loads a specific generation of an object from the bucket, for example to
+// roll back to a prior version of an object in a bucket with versioning enabled.
+func (s *Client) DownloadGeneration(ctx context.Context, bucket, key string, gen int64) ([]byte, error) {
+	return s.download(ctx, s.bucket(bucket).Object(key).Generation(gen))
+}
 
+// download reads the object entirely
+func (s *Client) download(ctx context.Context, object *storage.ObjectHandle) ([]byte, error) {
 	// Create a new reader for the object
 	r, err := object.NewReader(ctx)
 	if err != nil {
@@ -91,43 +317,282 @@ func (s *Client) Download(ctx context.Context, bucket, key string) ([]byte, erro
 	return ioutil.ReadAll(r)
 }
 
-// getLatestKey returns latest uploaded key in given bucket
-func (s *Client) getLatestKey(ctx context.Context, bucket, prefix string) (string, time.Time, error) {
-	handle := s.client.Bucket(bucket)
+// Fingerprint returns the entity tag of the object.
+func (s *Client) Fingerprint(ctx context.Context, uri string) (string, error) {
+	_, attrs, err := s.resolve(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+
+	return attrs.Etag, nil
+}
+
+// Exists checks whether the object exists by reading its attributes.
+func (s *Client) Exists(ctx context.Context, uri string) (bool, error) {
+	_, _, err := s.resolve(ctx, uri)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// Stream opens a reader for the object, without buffering it.
+func (s *Client) Stream(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return s.openRange(ctx, uri, 0, -1)
+}
+
+// DownloadTo downloads the object into the writer and returns the number of bytes written.
+func (s *Client) DownloadTo(ctx context.Context, uri string, w io.Writer) (int64, error) {
+	r, err := s.Stream(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// DownloadHead downloads the first n bytes of the object using a ranged request.
+func (s *Client) DownloadHead(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, 0, n)
+}
+
+// DownloadTail downloads the last n bytes of the object using a ranged request.
+func (s *Client) DownloadTail(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, -n, -1)
+}
+
+// DownloadRange downloads length bytes of the object starting at the offset using a ranged
+// request.
+func (s *Client) DownloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, offset, length)
+}
+
+// downloadRange downloads a specific byte range of the object
+func (s *Client) downloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	r, err := s.openRange(ctx, uri, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// openRange opens a reader for a specific byte range of the object
+func (s *Client) openRange(ctx context.Context, uri string, offset, length int64) (io.ReadCloser, error) {
+	bucket, attrs, err := s.resolve(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.bucket(bucket).Object(attrs.Name).NewRangeReader(ctx, offset, length)
+}
+
+// List returns the URIs of all of the objects under the prefix, in lexicographical order.
+func (s *Client) List(ctx context.Context, uri string) ([]string, error) {
+	bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.TrimSuffix(prefix, "*")
+	uri = strings.TrimSuffix(uri, "*")
+
+	var keys []string
+	cursor := s.bucket(bucket).Objects(ctx, &storage.Query{
+		Prefix: prefix,
+	})
+	for {
+		o, err := cursor.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, resource.URIOf(uri, prefix, o.Name))
+	}
+}
+
+// WithMaxListing bounds the number of objects scanned under a prefix when looking for the
+// latest object. Once the cap is reached, the latest object scanned so far is selected.
+func WithMaxListing(n int) func(*Client) {
+	return func(s *Client) {
+		s.maxListing = n
+	}
+}
+
+// resolve returns the bucket and the attributes of the object to download. The key is a prefix
+// (a trailing '*' is ignored) and the latest object under it is resolved, unless the keys are
+// matched exactly, in which case the attributes of the exact key are read without listing.
+func (s *Client) resolve(ctx context.Context, uri string) (string, *storage.ObjectAttrs, error) {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var attrs *storage.ObjectAttrs
+	prefix, ok := resource.PrefixOf(key)
+	if !ok || s.exact || resource.IsExactKeys(ctx) {
+		attrs, err = s.attrsOf(ctx, bucket, key)
+	} else {
+		attrs, err = s.getLatestKey(ctx, bucket, prefix)
+	}
+	return bucket, attrs, err
+}
+
+// attrsOf returns the attributes of the object with the exact key
+func (s *Client) attrsOf(ctx context.Context, bucket, key string) (*storage.ObjectAttrs, error) {
+	attrs, err := s.bucket(bucket).Object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNoSuchKey
+	}
+	return attrs, err
+}
+
+// WithExactKeys makes the client always download the object with the exact key of the URI,
+// even if it ends with a '/' or a '*', instead of the latest object under that prefix.
+func WithExactKeys() func(*Client) {
+	return func(s *Client) {
+		s.exact = true
+	}
+}
+
+// WithKeyFilter sets a filter of the keys which can be selected as the latest object under a
+// prefix, for example to exclude the '.tmp' or '_SUCCESS' marker files written by Spark or
+// Hadoop. Only the keys for which the filter returns true are considered.
+func WithKeyFilter(fn func(name string) bool) func(*Client) {
+	return func(s *Client) {
+		s.keyFilter = fn
+	}
+}
+
+// ObjectInfo represents an object listed under a prefix, as a candidate for the selection.
+type ObjectInfo struct {
+	Key          string    // The key of the object
+	Size         int64     // The size of the object, in bytes
+	LastModified time.Time // The time of the last update of the object
+	ETag         string    // The entity tag of the object
+	Generation   int64     // The generation of the object
+}
+
+// Selector selects the object to download among the objects listed under a prefix, returning
+// false if none of them should be selected.
+type Selector func(objects []ObjectInfo) (ObjectInfo, bool)
+
+// WithSelector sets the strategy selecting the object to download among the objects listed
+// under a prefix, for example to pick the lexicographically-last key of a date-partitioned
+// path. The candidates passed are the ones which hold data and pass the key filter, if any. By
+// default, the most recently updated object is selected.
+func WithSelector(fn Selector) func(*Client) {
+	return func(s *Client) {
+		s.selector = fn
+	}
+}
+
+// getLatestKey returns the attributes of the latest uploaded key in given bucket, or of the key
+// chosen by the selector if one is set.
+func (s *Client) getLatestKey(ctx context.Context, bucket, prefix string) (*storage.ObjectAttrs, error) {
+	handle := s.bucket(bucket)
 	cursor := handle.Objects(ctx, &storage.Query{
 		Prefix: prefix,
 	})
 
-	var updatedKey string
-	var updatedAt time.Time
-	for {
+	var latest *storage.ObjectAttrs
+	var candidates []*storage.ObjectAttrs
+	for count := 0; s.maxListing <= 0 || count < s.maxListing; count++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		o, err := cursor.Next()
 		if err == iterator.Done {
 			break
 		}
 
 		if err != nil {
-			return "", time.Time{}, err
+			return nil, err
 		}
 
-		if o.Size > 0 && isModified(o.Updated, updatedAt) {
-			updatedKey = o.Name
-			updatedAt = o.Updated
+		switch {
+		case !s.isData(o):
+			continue
+		case s.selector != nil:
+			candidates = append(candidates, o)
+		case latest == nil || isModified(o.Updated, latest.Updated):
+			latest = o
 		}
 	}
 
-	if updatedKey == "" {
-		return "", time.Time{}, ErrNoSuchKey
+	if s.selector != nil {
+		latest = s.selectFrom(candidates)
 	}
-	return updatedKey, updatedAt, nil
+
+	if latest == nil {
+		return nil, ErrNoSuchKey
+	}
+	return latest, nil
+}
+
+// selectFrom returns the attributes of the candidate chosen by the selector, or nil if none
+func (s *Client) selectFrom(candidates []*storage.ObjectAttrs) *storage.ObjectAttrs {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	objects := make([]ObjectInfo, 0, len(candidates))
+	for _, o := range candidates {
+		objects = append(objects, ObjectInfo{
+			Key:          o.Name,
+			Size:         o.Size,
+			LastModified: o.Updated,
+			ETag:         o.Etag,
+			Generation:   o.Generation,
+		})
+	}
+
+	selected, ok := s.selector(objects)
+	if !ok {
+		return nil
+	}
+
+	for _, o := range candidates {
+		if o.Name == selected.Key {
+			return o
+		}
+	}
+	return nil
+}
+
+// isData returns whether the object holds actual data, as opposed to an empty object, a folder
+// placeholder or a key excluded by the filter.
+func (s *Client) isData(o *storage.ObjectAttrs) bool {
+	return o.Size > 0 && !strings.HasSuffix(o.Name, "/") &&
+		(s.keyFilter == nil || s.keyFilter(o.Name))
+}
+
+// bucket returns the handle of the bucket, billing the user project if any
+func (s *Client) bucket(name string) *storage.BucketHandle {
+	handle := s.client.Bucket(name)
+	if s.project != "" {
+		handle = handle.UserProject(s.project)
+	}
+	return handle
 }
 
 func isModified(updatedAt, updatedSince time.Time) bool {
-	return updatedAt.UTC().Unix() > updatedSince.UTC().Unix()
+	return updatedAt.After(updatedSince)
 }
 
-// LoadCredentials loads the appropriate credentials
-func loadCredentials() (*google.Credentials, error) {
+// LoadCredentials loads the appropriate credentials for the scope
+func loadCredentials(scope string) (*google.Credentials, error) {
 	if v := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_RAW"); v != "" {
 		return google.CredentialsFromJSON(context.Background(), []byte(v), scope)
 	}
@@ -135,12 +600,58 @@ func loadCredentials() (*google.Credentials, error) {
 	return google.FindDefaultCredentials(context.Background(), scope)
 }
 
-// parseURI returns bucket and prefix
+// statusError is an error of the storage API along with the status code of its response, so
+// that the throttling and the server errors can be told apart from the permanent ones.
+type statusError struct {
+	err  error // The underlying error
+	code int   // The status code of the response
+}
+
+// Error returns the error message
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// StatusCode returns the status code of the response
+func (e *statusError) StatusCode() int {
+	return e.code
+}
+
+// convertError exposes the status code of the errors returned by the storage API
+func convertError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return &statusError{err: err, code: apiErr.Code}
+	}
+	return err
+}
+
+// endpoint matches the storage endpoint host, with an optional virtual-hosted bucket
+var endpoint = regexp.MustCompile(`^(?:(.+)\.)?storage\.googleapis\.com$`)
+
+// parseURI returns bucket and prefix. The entire host is the bucket (e.g. 'gs://my.bucket/key'),
+// unless it is the storage endpoint, in which case the bucket is either the virtual-hosted
+// prefix of the host or, for path-style URIs, the first segment of the path.
 func parseURI(uri string) (string, string, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return "", "", err
 	}
 
-	return strings.Split(u.Host, ".")[0], strings.TrimLeft(u.Path, "/"), nil
+	path := strings.TrimLeft(u.Path, "/")
+	match := endpoint.FindStringSubmatch(u.Host)
+	switch {
+	case match == nil:
+		return u.Host, path, nil
+	case match[1] != "":
+		return match[1], path, nil
+	default:
+		bucket, prefix, _ := strings.Cut(path, "/")
+		return bucket, prefix, nil
+	}
 }
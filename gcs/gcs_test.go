@@ -4,18 +4,37 @@
 package gcs
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/kelindar/loader"
+	"github.com/kelindar/loader/resource"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 )
 
 func TestGCS(t *testing.T) {
@@ -48,22 +67,678 @@ func TestGCS(t *testing.T) {
 
 	// Test DownloadNewer
 	{
-		val, err := cli.DownloadIf(context.Background(), "gs://bucket/h", time.Unix(0, 0))
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/h*", time.Unix(0, 0))
 		assert.NoError(t, err)
 		assert.Equal(t, inputVal, val)
 	}
+
+	// Test DownloadExactIf
+	{
+		listings := gcs.Listings
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/hi.txt", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+
+		val, err = cli.DownloadExactIf(context.Background(), bucket, "hi.txt", time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+
+		_, err = cli.DownloadExactIf(context.Background(), bucket, "missing.txt", time.Unix(0, 0))
+		assert.Equal(t, ErrNoSuchKey, err)
+		assert.Equal(t, listings, gcs.Listings)
+	}
+
+	// Test the exact keys sharing a prefix with a newer object
+	{
+		listings := gcs.Listings
+		gcs.PutObject("hi.txt.bak", []byte("backup"))
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/hi.txt", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+
+		val, err = cli.DownloadIf(context.Background(), "gs://bucket/hi*", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "backup", string(val))
+		assert.Equal(t, listings+1, gcs.Listings)
+		delete(gcs.Objects, "hi.txt.bak")
+	}
+
+	// Test DownloadMeta
+	{
+		val, meta, err := cli.DownloadMeta(context.Background(), "gs://bucket/hi.txt", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, inputVal, val)
+		assert.Equal(t, int64(len(inputVal)), meta.Size)
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum(inputVal)), meta.ETag)
+		assert.Equal(t, "text/plain", meta.ContentType)
+		assert.False(t, meta.LastModified.IsZero())
+	}
+
+	// Test List
+	{
+		keys, err := cli.List(context.Background(), "gs://bucket/h")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"gs://bucket/hello.txt", "gs://bucket/hi.txt"}, keys)
+
+		keys, err = cli.List(context.Background(), "gs://bucket/h*")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"gs://bucket/hello.txt", "gs://bucket/hi.txt"}, keys)
+
+		keys, err = cli.List(context.Background(), "https://storage.googleapis.com/bucket/hi")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://storage.googleapis.com/bucket/hi.txt"}, keys)
+	}
+
+	// Test DownloadHead and DownloadTail
+	{
+		head, err := cli.DownloadHead(context.Background(), "gs://bucket/hi.txt", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(head))
+		tail, err := cli.DownloadTail(context.Background(), "gs://bucket/hi.txt", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(tail))
+		mid, err := cli.DownloadRange(context.Background(), "gs://bucket/hi.txt", 3, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "lo wo", string(mid))
+	}
+
+	// Test Stream
+	{
+		r, err := cli.Stream(context.Background(), "gs://bucket/hi.txt")
+		assert.NoError(t, err)
+		val, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, inputVal, val)
+	}
+
+	// Test Fingerprint
+	{
+		fp1, err := cli.Fingerprint(context.Background(), "gs://bucket/hi.txt")
+		assert.NoError(t, err)
+		fp2, err := cli.Fingerprint(context.Background(), "gs://bucket/hi.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, fp1, fp2)
+		gcs.PutObject("hi.txt", []byte("hi there"))
+		fp3, err := cli.Fingerprint(context.Background(), "gs://bucket/hi.txt")
+		assert.NoError(t, err)
+		assert.NotEqual(t, fp1, fp3)
+	}
+
+	// Test Exists
+	for uri, expect := range map[string]bool{
+		"gs://bucket/hi.txt":      true,
+		"gs://bucket/h*":          true,
+		"gs://bucket/missing.txt": false,
+		"gs://bucket/missing/":    false,
+	} {
+		ok, err := cli.Exists(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, expect, ok, uri)
+	}
+}
+
+func TestGCSWithHTTPClient(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	gcs.PutObject("hi.txt", []byte("hello world"))
+
+	// Create a new GCS layer with a counting transport
+	transport := &countingTransport{next: http.DefaultTransport}
+	cli, err := New(WithHTTPClient(&http.Client{Transport: transport}))
+	assert.NoError(t, err)
+
+	val, err := cli.DownloadIf(context.Background(), "gs://bucket/hi*", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(val))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&transport.count)) // list + get
+}
+
+func TestGCSWithCredentials(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	gcs.PutObject("hi.txt", []byte("hello world"))
+
+	var auth atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"secret","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+
+		auth.Store(r.Header.Get("Authorization"))
+		gcs.serve(w, r)
+	}))
+	defer ts.Close()
+
+	// Authenticate against the fake server instead of the emulator
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+
+	// Create a service account key with a token endpoint of the fake server
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jsonKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "test@project.iam.gserviceaccount.com",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+		"token_uri": ts.URL + "/token",
+	})
+	assert.NoError(t, err)
+
+	for _, newClient := range []func() (*Client, error){
+		func() (*Client, error) { return NewWithCredentials(jsonKey) },
+		func() (*Client, error) {
+			return NewWithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "secret"}))
+		},
+	} {
+		cli, err := newClient()
+		assert.NoError(t, err)
+
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/hi.txt", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(val))
+		assert.Equal(t, "Bearer secret", auth.Load())
+	}
+
+	_, err = NewWithCredentials([]byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestGCSUserProject(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	gcs.PutObject("hi.txt", []byte("hello world"))
+
+	var projects []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("userProject") // JSON API
+		if project == "" {
+			project = r.Header.Get("X-Goog-User-Project") // XML API
+		}
+
+		projects = append(projects, project)
+		gcs.serve(w, r)
+	}))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New(WithUserProject("my-project"))
+	assert.NoError(t, err)
+
+	for _, uri := range []string{"gs://bucket/hi.txt", "gs://bucket/h*"} {
+		projects = nil
+		val, err := cli.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(val))
+		assert.Equal(t, []string{"my-project", "my-project"}, projects, uri)
+	}
+}
+
+func TestGCSGeneration(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	gcs.PutObject("app.json", []byte("v1"))
+	gcs.PutObject("app.json", []byte("v2"))
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New()
+	assert.NoError(t, err)
+
+	for _, uri := range []string{"gs://bucket/app.json", "gs://bucket/app*"} {
+		val, meta, err := cli.DownloadMeta(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(val))
+		assert.Equal(t, int64(2), meta.Generation)
+	}
+
+	{ // Prior generation
+		val, err := cli.DownloadGeneration(context.Background(), "bucket", "app.json", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", string(val))
+	}
+
+	{ // Missing generation
+		_, err := cli.DownloadGeneration(context.Background(), "bucket", "app.json", 3)
+		assert.Error(t, err)
+	}
+}
+
+func TestGCSStat(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	gcs.PutObject("hello.txt", []byte("hello world"))
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New()
+	assert.NoError(t, err)
+
+	for _, uri := range []string{"gs://bucket/hello.txt", "gs://bucket/hel*"} {
+		meta, err := cli.Stat(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, int64(11), meta.Size, uri)
+		assert.Equal(t, time.Unix(0, gcs.Objects["hello.txt"].ModifiedAt).UTC(), meta.LastModified.UTC(), uri)
+		assert.Equal(t, int64(1), meta.Generation, uri)
+	}
+
+	_, err = cli.Stat(context.Background(), "gs://bucket/missing.txt")
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+}
+
+func TestGCSUpload(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New(WithWriteAccess())
+	assert.NoError(t, err)
+
+	assert.NoError(t, cli.Upload(context.Background(), "gs://bucket/a/hello.txt", []byte("hello world")))
+	b, err := cli.DownloadIf(context.Background(), "gs://bucket/a/hello.txt", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+	assert.Equal(t, int64(1), gcs.Objects["a/hello.txt"].Generation)
+}
+
+func TestGCSScope(t *testing.T) {
+	cli, err := New()
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ScopeReadOnly, cli.scope())
+
+	cli, err = New(WithWriteAccess())
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ScopeReadWrite, cli.scope())
+}
+
+func TestGCSStatusError(t *testing.T) {
+	err := convertError(fmt.Errorf("storage: %w", &googleapi.Error{Code: 503, Message: "unavailable"}))
+	assert.Equal(t, 503, err.(interface{ StatusCode() int }).StatusCode())
+
+	var apiErr *googleapi.Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, ErrNoSuchKey, convertError(ErrNoSuchKey))
+}
+
+func TestGCSPagination(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	gcs.PageSize = 1
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	for i, key := range []string{"a", "b", "c"} {
+		gcs.Objects[key+".txt"] = object{
+			Key:        key + ".txt",
+			ModifiedAt: time.Now().Add(time.Duration(i) * time.Second).UnixNano(),
+			Value:      []byte(key),
+		}
+	}
+
+	{ // All of the pages are walked
+		cli, err := New()
+		assert.NoError(t, err)
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "c", string(val))
+		assert.Equal(t, 3, gcs.Listings)
+	}
+
+	{ // The listing is bounded
+		cli, err := New(WithMaxListing(1))
+		assert.NoError(t, err)
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "a", string(val))
+	}
+
+	{ // The listing is canceled
+		cli, err := New()
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		val, err := cli.DownloadIf(ctx, "gs://bucket/", time.Unix(0, 0))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, val)
+	}
+}
+
+func TestGCSSelector(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+
+	// The lexicographic order, the modification order and the size order disagree
+	gcs.PutObject("data/2024-01-02/a.json", []byte("last"))
+	gcs.PutObject("data/2024-01-01/a.json", []byte("largest"))
+	gcs.PutObject("data/2023-12-31/a.json", []byte("newest"))
+	gcs.Touch("data/2024-01-02/a.json", -2*time.Hour)
+	gcs.Touch("data/2024-01-01/a.json", -time.Hour)
+
+	lastKey := func(objects []ObjectInfo) (ObjectInfo, bool) {
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+		return objects[len(objects)-1], true
+	}
+
+	largest := func(objects []ObjectInfo) (ObjectInfo, bool) {
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Size < objects[j].Size })
+		return objects[len(objects)-1], true
+	}
+
+	none := func(objects []ObjectInfo) (ObjectInfo, bool) {
+		return ObjectInfo{}, false
+	}
+
+	for _, tc := range []struct {
+		options []func(*Client)
+		expect  string
+	}{
+		{expect: "newest"},
+		{options: []func(*Client){WithSelector(lastKey)}, expect: "last"},
+		{options: []func(*Client){WithSelector(largest)}, expect: "largest"},
+		{options: []func(*Client){WithSelector(none)}},
+	} {
+		cli, err := New(tc.options...)
+		assert.NoError(t, err)
+
+		val, err := cli.DownloadIf(context.Background(), "gs://bucket/data/", time.Unix(0, 0))
+		if tc.expect == "" {
+			assert.ErrorIs(t, err, ErrNoSuchKey)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, string(val))
+	}
+}
+
+func TestGCSExactKeys(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+
+	// The named object is a prefix of a newer object
+	gcs.PutObject("data*", []byte("named"))
+	gcs.PutObject("data-2.json", []byte("newest"))
+	gcs.Touch("data*", -time.Hour)
+
+	cli, err := New()
+	assert.NoError(t, err)
+	exact, err := New(WithExactKeys())
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		load   func() ([]byte, error)
+		expect string
+	}{
+		{expect: "newest", load: func() ([]byte, error) {
+			return cli.DownloadIf(context.Background(), "gs://bucket/data*", time.Unix(0, 0))
+		}},
+		{expect: "named", load: func() ([]byte, error) {
+			return exact.DownloadIf(context.Background(), "gs://bucket/data*", time.Unix(0, 0))
+		}},
+		{expect: "newest", load: func() ([]byte, error) {
+			return loader.New(loader.WithGCS(cli)).Load(context.Background(), "gs://bucket/data*")
+		}},
+		{expect: "named", load: func() ([]byte, error) {
+			return loader.New(loader.WithGCS(cli), loader.WithExactKeys()).Load(context.Background(), "gs://bucket/data*")
+		}},
+	} {
+		b, err := tc.load()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, string(b))
+	}
+}
+
+func TestGCSMetadataTrigger(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New(WithMetadataTrigger("x-goog-meta-version"))
+	assert.NoError(t, err)
+
+	gcs.PutObject("config.json", []byte("v1"))
+	gcs.PutObject("plain.json", []byte("v1"))
+	gcs.SetMetadata("config.json", "version", "1")
+	for _, uri := range []string{"gs://bucket/config.json", "gs://bucket/plain.json"} {
+		b, err := cli.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", string(b))
+	}
+
+	// The version last seen by the caller
+	_, last, err := cli.DownloadMeta(context.Background(), "gs://bucket/config.json", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", last.Marker)
+
+	{ // Rewritten with the same version, not modified despite the newer modification time
+		since := time.Now().Add(-time.Minute)
+		gcs.PutObject("config.json", []byte("v1 rewritten"))
+		gcs.SetMetadata("config.json", "version", "1")
+		b, err := cli.DownloadIf(resource.LastSeen(context.Background(), last), "gs://bucket/config.json", since)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // A new version is modified despite the older modification time, even once another
+		// caller has downloaded it
+		gcs.PutObject("config.json", []byte("v2"))
+		gcs.SetMetadata("config.json", "version", "2")
+		gcs.Touch("config.json", -time.Hour)
+		_, err := cli.DownloadIf(context.Background(), "gs://bucket/config.json", time.Unix(0, 0))
+		assert.NoError(t, err)
+
+		b, meta, err := cli.DownloadMeta(resource.LastSeen(context.Background(), last), "gs://bucket/config.json", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+
+		b, err = cli.DownloadIf(resource.LastSeen(context.Background(), meta), "gs://bucket/config.json", time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Without the key, the modification time is used
+		since := time.Now().Add(-time.Minute)
+		gcs.PutObject("plain.json", []byte("v2"))
+		b, err := cli.DownloadIf(context.Background(), "gs://bucket/plain.json", since)
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+	}
+}
+
+func TestGCSKeyFilter(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+
+	// The folder marker and the success marker are the most recent
+	gcs.PutObject("data/part-0.json", []byte("data"))
+	gcs.PutObject("data/nested/", []byte("marker"))
+	gcs.PutObject("data/_SUCCESS", []byte("done"))
+	gcs.Touch("data/part-0.json", -2*time.Second)
+	gcs.Touch("data/nested/", -time.Second)
+
+	cli, err := New(WithKeyFilter(func(name string) bool {
+		return !strings.HasSuffix(name, "_SUCCESS")
+	}))
+	assert.NoError(t, err)
+
+	val, err := cli.DownloadIf(context.Background(), "gs://bucket/data/", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(val))
+
+	// Without a filter, the success marker is selected
+	cli, err = New()
+	assert.NoError(t, err)
+	val, err = cli.DownloadIf(context.Background(), "gs://bucket/data/", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "done", string(val))
+}
+
+func TestWatchPrefix(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	gcs.PutObject("data/a.txt", []byte("a"))
+
+	cli, err := New()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The existing key is emitted initially
+	updates := loader.New(loader.WithGCS(cli)).WatchPrefix(ctx, "gs://bucket/data/", 10*time.Millisecond)
+	u := <-updates
+	assert.NoError(t, u.Err)
+	assert.Equal(t, "gs://bucket/data/a.txt", u.Key)
+	assert.Equal(t, "a", string(u.Data))
+
+	// A key added during the watch is emitted
+	gcs.Lock()
+	gcs.PutObject("data/b.txt", []byte("b"))
+	gcs.Unlock()
+
+	select {
+	case u := <-updates:
+		assert.NoError(t, u.Err)
+		assert.Equal(t, "gs://bucket/data/b.txt", u.Key)
+		assert.Equal(t, "b", string(u.Data))
+	case <-time.After(time.Second):
+		assert.Fail(t, "expected an update of the new key")
+	}
+
+	cancel()
+	for range updates {
+	}
+}
+
+func TestDownloadIfNewer(t *testing.T) {
+	gcs := new(fakeGCS)
+	gcs.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(gcs.serve))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	os.Setenv("STORAGE_EMULATOR_ENDPOINT", ts.URL)
+	cli, err := New()
+	assert.NoError(t, err)
+
+	// Empty bucket
+	_, _, err = cli.DownloadIfNewer(context.Background(), "bucket", "", time.Unix(0, 0))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	// Add a few objects, one second apart
+	gcs.PutObject("data/a.txt", []byte("a"))
+	gcs.PutObject("data/b.txt", []byte("b"))
+	gcs.PutObject("other.txt", []byte("c"))
+	gcs.Touch("data/a.txt", -2*time.Second)
+	gcs.Touch("data/b.txt", -time.Second)
+	updatedAt := time.Unix(0, gcs.Objects["data/b.txt"].ModifiedAt)
+
+	{ // Newer
+		b, at, err := cli.DownloadIfNewer(context.Background(), "bucket", "data/", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "b", string(b))
+		assert.Equal(t, updatedAt.UnixNano(), at.UnixNano())
+	}
+
+	{ // Not newer
+		b, at, err := cli.DownloadIfNewer(context.Background(), "bucket", "data/", updatedAt.Add(time.Second))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+		assert.Equal(t, updatedAt.UnixNano(), at.UnixNano())
+	}
+
+	{ // Empty prefix
+		b, _, err := cli.DownloadIfNewer(context.Background(), "bucket", "", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "c", string(b))
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri, bucket, prefix string
+	}{
+		{"gs://bucket/prefix", "bucket", "prefix"},
+		{"gs://my.data.bucket/dir/prefix", "my.data.bucket", "dir/prefix"},
+		{"https://my.data.bucket.storage.googleapis.com/prefix", "my.data.bucket", "prefix"},
+		{"https://storage.googleapis.com/my.data.bucket/dir/prefix", "my.data.bucket", "dir/prefix"},
+	} {
+		bucket, prefix, err := parseURI(tc.uri)
+		assert.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.bucket, bucket, tc.uri)
+		assert.Equal(t, tc.prefix, prefix, tc.uri)
+	}
+}
+
+// countingTransport counts the requests made through it
+type countingTransport struct {
+	count int64
+	next  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.count, 1)
+	return t.next.RoundTrip(r)
 }
 
 // fakeGCS represents a fake GCS server
 type fakeGCS struct {
 	sync.Mutex
-	Objects map[string]object
+	Objects  map[string]object
+	PageSize int // The number of objects per page, zero for all
+	Listings int // The number of list requests served
 }
 
 type object struct {
 	Key        string
 	ModifiedAt int64
 	Value      []byte
+	Generation int64             // The generation of the current value
+	Versions   map[int64][]byte  // The values of the prior generations
+	Metadata   map[string]string // The custom metadata of the object
 }
 
 // serve called on every HTTP request
@@ -71,11 +746,13 @@ func (s *fakeGCS) serve(w http.ResponseWriter, r *http.Request) {
 	s.Lock()
 	defer s.Unlock()
 
-	println(r.Method, r.URL.String(), string(valueOf(r)))
-
 	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/"):
+		s.UploadObject(w, r)
 	case r.Method == http.MethodGet && strings.Contains(r.URL.String(), "/o?"):
 		s.ListObjects(w, r)
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/o/"):
+		s.GetAttrs(w, r)
 	case r.Method == http.MethodGet:
 		s.GetObject(w, r)
 	default:
@@ -87,38 +764,148 @@ func (s *fakeGCS) serve(w http.ResponseWriter, r *http.Request) {
 func (s *fakeGCS) ListObjects(w http.ResponseWriter, r *http.Request) {
 	var matches []*Object
 	prefix := r.URL.Query().Get("prefix")
+	s.Listings++
 
-	for _, o := range s.Objects {
-		if strings.HasPrefix(o.Key, prefix) {
+	keys := make([]string, 0, len(s.Objects))
+	for k := range s.Objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if o := s.Objects[k]; strings.HasPrefix(o.Key, prefix) {
 			matches = append(matches, &Object{
-				Bucket:  "bucket",
-				Name:    o.Key,
-				Updated: time.Unix(0, o.ModifiedAt).UTC().Format(time.RFC3339Nano),
-				Size:    uint64(len(o.Value)),
+				Bucket:      "bucket",
+				Name:        o.Key,
+				Updated:     time.Unix(0, o.ModifiedAt).UTC().Format(time.RFC3339Nano),
+				Size:        uint64(len(o.Value)),
+				Etag:        fmt.Sprintf("%x", md5.Sum(o.Value)),
+				ContentType: "text/plain",
+				Generation:  o.Generation,
+				Metadata:    o.Metadata,
 			})
 		}
 	}
 
+	// Paginate the results, if required
 	var resp Objects
 	resp.Items = matches
+	if s.PageSize > 0 {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		resp.Items = matches[offset:min(offset+s.PageSize, len(matches))]
+		if offset+s.PageSize < len(matches) {
+			resp.NextPageToken = strconv.Itoa(offset + s.PageSize)
+		}
+	}
+
 	b, _ := json.Marshal(resp)
 	w.Write(b)
 }
 
-// PutObject emulates GCS put object
+// GetAttrs emulates GCS get object metadata
+func (s *fakeGCS) GetAttrs(w http.ResponseWriter, r *http.Request) {
+	_, key, _ := strings.Cut(r.URL.Path, "/o/")
+	o, ok := s.Objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	b, _ := json.Marshal(&Object{
+		Bucket:      "bucket",
+		Name:        o.Key,
+		Updated:     time.Unix(0, o.ModifiedAt).UTC().Format(time.RFC3339Nano),
+		Size:        uint64(len(o.Value)),
+		Etag:        fmt.Sprintf("%x", md5.Sum(o.Value)),
+		ContentType: "text/plain",
+		Generation:  o.Generation,
+		Metadata:    o.Metadata,
+	})
+	w.Write(b)
+}
+
+// PutObject emulates GCS put object, keeping the prior generations of the object
 func (s *fakeGCS) PutObject(key string, value []byte) {
+	versions := make(map[int64][]byte)
+	prev, ok := s.Objects[key]
+	if ok {
+		versions = prev.Versions
+		versions[prev.Generation] = prev.Value
+	}
+
 	s.Objects[key] = object{
 		Key:        key,
 		ModifiedAt: time.Now().UnixNano(),
 		Value:      value,
+		Generation: prev.Generation + 1,
+		Versions:   versions,
+	}
+}
+
+// UploadObject emulates GCS multipart upload
+func (s *fakeGCS) UploadObject(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The first part is the metadata, the second one is the content
+	var attrs Object
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	for i := 0; i < 2; i++ {
+		part, err := parts.NextPart()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch b, _ := io.ReadAll(part); i {
+		case 0:
+			json.Unmarshal(b, &attrs)
+		case 1:
+			s.PutObject(attrs.Name, b)
+		}
 	}
+
+	o := s.Objects[attrs.Name]
+	b, _ := json.Marshal(&Object{
+		Bucket:     "bucket",
+		Name:       o.Key,
+		Updated:    time.Unix(0, o.ModifiedAt).UTC().Format(time.RFC3339Nano),
+		Size:       uint64(len(o.Value)),
+		Generation: o.Generation,
+	})
+	w.Write(b)
+}
+
+// SetMetadata sets a custom metadata value of an object
+func (s *fakeGCS) SetMetadata(key, name, value string) {
+	o := s.Objects[key]
+	o.Metadata = map[string]string{name: value}
+	s.Objects[key] = o
+}
+
+// Touch shifts the modification time of an object
+func (s *fakeGCS) Touch(key string, offset time.Duration) {
+	o := s.Objects[key]
+	o.ModifiedAt = time.Now().Add(offset).UnixNano()
+	s.Objects[key] = o
 }
 
 // GetObject emulates GCS get object
 func (s *fakeGCS) GetObject(w http.ResponseWriter, r *http.Request) {
 	key := keyOf(r)
 	if o, ok := s.Objects[key]; ok {
-		w.Write(o.Value)
+		value := o.Value
+		if gen, err := strconv.ParseInt(r.URL.Query().Get("generation"), 10, 64); err == nil && gen != o.Generation {
+			if value, ok = o.Versions[gen]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+
+		http.ServeContent(w, r, key, time.Unix(0, o.ModifiedAt), bytes.NewReader(value))
 		return
 	}
 
@@ -126,8 +913,8 @@ func (s *fakeGCS) GetObject(w http.ResponseWriter, r *http.Request) {
 }
 
 func keyOf(r *http.Request) string {
-	url := r.URL.String()
-	return url[2+strings.Index(url[1:], "/"):]
+	path := r.URL.Path
+	return path[2+strings.Index(path[1:], "/"):]
 }
 
 func valueOf(r *http.Request) []byte {
@@ -145,8 +932,12 @@ type Objects struct {
 }
 
 type Object struct {
-	Bucket  string `json:"bucket,omitempty"`
-	Name    string `json:"name,omitempty"`
-	Updated string `json:"updated,omitempty"`
-	Size    uint64 `json:"size,omitempty,string"`
+	Bucket      string            `json:"bucket,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	Size        uint64            `json:"size,omitempty,string"`
+	Etag        string            `json:"etag,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Generation  int64             `json:"generation,omitempty,string"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 }
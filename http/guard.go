@@ -0,0 +1,144 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	stdhttp "net/http"
+)
+
+// ErrBlockedHost is returned when the host of a request resolves to a private network
+var ErrBlockedHost = errors.New("host is not allowed")
+
+// sharedAddressSpace is the range of the carrier-grade NAT (RFC 6598), which is not public
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+// nat64Prefix is the well-known prefix of the IPv6 addresses embedding an IPv4 one (RFC 6052)
+var nat64Prefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// WithDenyPrivateNetworks rejects the requests, including the redirects, to the hosts which
+// resolve to a loopback, link-local, shared or private address (e.g. 'http://localhost/' or
+// the cloud metadata endpoint at 'http://169.254.169.254/') with ErrBlockedHost. This should be
+// used when the URIs to load come from untrusted input.
+//
+// The addresses are checked when connecting and the vetted address itself is dialed, so a host
+// can not resolve to a different address in between. For the same reason, the requests are
+// never sent through a proxy. If the client has a custom transport, the connections are out of
+// its control and the host is only resolved and checked before sending the request.
+func WithDenyPrivateNetworks() func(*Client) {
+	return func(c *Client) {
+		c.denyPrivate = true
+	}
+}
+
+// guard returns a copy of the transport which only connects to the public addresses
+func guard(rt stdhttp.RoundTripper) stdhttp.RoundTripper {
+	if rt == nil {
+		rt = stdhttp.DefaultTransport
+	}
+
+	transport, ok := rt.(*stdhttp.Transport)
+	if !ok {
+		return &guardedTransport{next: rt}
+	}
+
+	dialer := &guardedDialer{
+		lookup: net.DefaultResolver.LookupIPAddr,
+		dial:   new(net.Dialer).DialContext,
+	}
+
+	transport = transport.Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// guardedDialer resolves the host of every connection and dials the resolved address itself,
+// rather than the host, unless any of the addresses belongs to a private network.
+type guardedDialer struct {
+	lookup lookupFunc // Resolves the addresses of a host
+	dial   dialFunc   // Connects to an address
+}
+
+// lookupFunc resolves the addresses of a host
+type lookupFunc = func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// dialFunc connects to an address
+type dialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialContext connects to the first reachable address of the host, unless the host is blocked
+func (d *guardedDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return nil, fmt.Errorf("%w: %s", ErrBlockedHost, host)
+		}
+	}
+
+	// Dial the vetted addresses, so the host is not resolved again
+	err = fmt.Errorf("no addresses found for %s", host)
+	for _, addr := range addrs {
+		conn, dialErr := d.dial(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+	}
+	return nil, err
+}
+
+// guardedTransport resolves the host of every request and rejects it before sending it if any
+// of the addresses belongs to a private network. It is only used for the custom transports,
+// since the host may be resolved again when connecting.
+type guardedTransport struct {
+	next stdhttp.RoundTripper // The transport to send the allowed requests with
+}
+
+// RoundTrip sends the request, unless its host is blocked
+func (t *guardedTransport) RoundTrip(r *stdhttp.Request) (*stdhttp.Response, error) {
+	host := r.URL.Hostname()
+	addrs, err := net.DefaultResolver.LookupIPAddr(r.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return nil, fmt.Errorf("%w: %s", ErrBlockedHost, host)
+		}
+	}
+
+	return t.next.RoundTrip(r)
+}
+
+// isPrivate returns whether the address belongs to a loopback, link-local, shared or private
+// network, including the IPv6 addresses which embed such an IPv4 address.
+func isPrivate(ip net.IP) bool {
+	if nat64Prefix.Contains(ip) {
+		ip = ip[12:16]
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
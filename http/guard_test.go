@@ -0,0 +1,145 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyPrivateNetworks(t *testing.T) {
+	var hits int
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		hits++
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	client := New(WithDenyPrivateNetworks())
+	for _, uri := range []string{
+		ts.URL,
+		"http://127.0.0.1/",
+		"http://localhost/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+	} {
+		_, err := client.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.ErrorIs(t, err, ErrBlockedHost, uri)
+	}
+	assert.Zero(t, hits)
+
+	{ // Allowed by default
+		b, err := New().Download(ts.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, 1, hits)
+	}
+}
+
+func TestDenyPrivateNetworksWithClient(t *testing.T) {
+	client := NewWithClient(&stdhttp.Client{}, WithDenyPrivateNetworks())
+	_, err := client.Download("http://127.0.0.1/")
+	assert.ErrorIs(t, err, ErrBlockedHost)
+}
+
+func TestGuardedTransport(t *testing.T) {
+	var sent []string
+	transport := &guardedTransport{next: roundTripFunc(func(r *stdhttp.Request) (*stdhttp.Response, error) {
+		sent = append(sent, r.URL.Host)
+		return &stdhttp.Response{StatusCode: 200, Body: stdhttp.NoBody}, nil
+	})}
+
+	for _, host := range []string{"93.184.216.34", "8.8.8.8", "[2001:4860:4860::8888]", "127.0.0.1", "[::1]", "192.168.1.1"} {
+		r, _ := stdhttp.NewRequest("GET", "http://"+host+"/", nil)
+		transport.RoundTrip(r)
+	}
+	assert.Equal(t, []string{"93.184.216.34", "8.8.8.8", "[2001:4860:4860::8888]"}, sent)
+}
+
+func TestGuardedDialer(t *testing.T) {
+	var lookups int
+	var dialed []string
+	dialer := &guardedDialer{
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			lookups++
+			if lookups%2 == 1 { // Rebinds the host on every other lookup
+				return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+			}
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+		},
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+	}
+
+	// The vetted address itself is dialed, never the host
+	conn, err := dialer.DialContext(context.Background(), "tcp", "rebind.example.com:80")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, []string{"93.184.216.34:80"}, dialed)
+
+	// Once the host resolves to a private address, it is blocked
+	_, err = dialer.DialContext(context.Background(), "tcp", "rebind.example.com:80")
+	assert.ErrorIs(t, err, ErrBlockedHost)
+	assert.Equal(t, []string{"93.184.216.34:80"}, dialed)
+}
+
+func TestGuard(t *testing.T) {
+	transport, ok := guard(nil).(*stdhttp.Transport)
+	assert.True(t, ok)
+	assert.Nil(t, transport.Proxy)
+	assert.NotNil(t, transport.DialContext)
+
+	// Custom transports are checked before sending the requests
+	custom := roundTripFunc(func(r *stdhttp.Request) (*stdhttp.Response, error) {
+		return nil, nil
+	})
+	assert.IsType(t, &guardedTransport{}, guard(custom))
+}
+
+func TestIsPrivate(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":              true,
+		"10.1.2.3":               true,
+		"172.16.0.1":             true,
+		"192.168.0.1":            true,
+		"169.254.169.254":        true,
+		"0.0.0.0":                true,
+		"::1":                    true,
+		"fe80::1":                true,
+		"fd00::1":                true,
+		"100.64.0.1":             true,
+		"100.127.255.254":        true,
+		"::ffff:127.0.0.1":       true,
+		"::ffff:169.254.169.254": true,
+		"::ffff:100.64.0.1":      true,
+		"64:ff9b::a9fe:a9fe":     true,
+		"100.128.0.1":            false,
+		"::ffff:8.8.8.8":         false,
+		"64:ff9b::808:808":       false,
+		"8.8.8.8":                false,
+		"172.32.0.1":             false,
+		"2001:db8::1":            false,
+	}
+
+	for addr, expect := range tests {
+		assert.Equal(t, expect, isPrivate(net.ParseIP(addr)), addr)
+	}
+}
+
+// roundTripFunc is a transport implemented by a function
+type roundTripFunc func(*stdhttp.Request) (*stdhttp.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *stdhttp.Request) (*stdhttp.Response, error) {
+	return f(r)
+}
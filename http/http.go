@@ -5,60 +5,538 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"maps"
 	stdhttp "net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/imroc/req"
+	"github.com/kelindar/loader/resource"
 )
 
 const timeFormat = stdhttp.TimeFormat
 
+var (
+	// ErrTooManyRedirects is returned when a request is redirected more times than allowed
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrRedirectBlocked is returned when a request is redirected to a location which is not allowed
+	ErrRedirectBlocked = errors.New("redirect is not allowed")
+)
+
+// HTTPStatusError is returned when the server responds with a status code other than 2xx,
+// so that an error page is never mistaken for the resource itself.
+type HTTPStatusError struct {
+	Code       int           // The status code of the response
+	Status     string        // The status of the response (e.g. "404 Not Found")
+	RetryAfter time.Duration // The delay requested by the 'Retry-After' header, if any
+}
+
+// Error returns the error message
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status: %s", e.Status)
+}
+
+// Is reports whether the status code means the resource does not exist, so the error
+// matches fs.ErrNotExist.
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == fs.ErrNotExist && (e.Code == stdhttp.StatusNotFound || e.Code == stdhttp.StatusGone)
+}
+
 // Client represents the client implementation.
 type Client struct {
+	req         *req.Req   // The underlying request client
+	header      req.Header // The headers sent with every request
+	denyPrivate bool       // Whether the requests to private networks are rejected
+	rawEncoding bool       // Whether the transport decompression is disabled
+	matchETag   bool       // Whether an unchanged entity tag means not modified, even on a 200
+	authToken   tokenFunc  // The source of the bearer token sent with every request, if any
 }
 
+// tokenFunc returns a fresh bearer token
+type tokenFunc = func() (string, error)
+
 // New creates a new client for HTTP downloads.
-func New() *Client {
-	return &Client{}
+func New(options ...func(*Client)) *Client {
+	c := &Client{
+		req:    req.New(),
+		header: req.Header{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.setClient(c.req.Client())
+	return c
+}
+
+// NewWithClient creates a new client for HTTP downloads which uses the supplied HTTP client,
+// for example to configure proxies, custom certificate authorities or client certificates.
+func NewWithClient(client *stdhttp.Client, options ...func(*Client)) *Client {
+	c := New(options...)
+	c.setClient(client)
+	return c
+}
+
+// NewWithTLSConfig creates a new client for HTTP downloads which uses the TLS configuration,
+// for example to present a client certificate to the endpoints requiring mutual TLS or to trust
+// a custom certificate authority.
+func NewWithTLSConfig(config *tls.Config, options ...func(*Client)) *Client {
+	client := *req.New().Client()
+	transport := client.Transport.(*stdhttp.Transport).Clone()
+	transport.TLSClientConfig = config
+	client.Transport = transport
+	return NewWithClient(&client, options...)
+}
+
+// NewWithTransportDecompression creates a new client for HTTP downloads which controls whether
+// the responses with a 'Content-Encoding: gzip' are transparently decompressed by the transport.
+// Disabling it returns the raw bytes as stored, for example to retrieve '.gz' artifacts as is.
+func NewWithTransportDecompression(enabled bool, options ...func(*Client)) *Client {
+	return New(append([]func(*Client){func(c *Client) {
+		c.rawEncoding = !enabled
+	}}, options...)...)
+}
+
+// NewWithHeaders creates a new client for HTTP downloads which sends the headers with every
+// request, for example an 'Accept' header to request a specific representation of a resource or
+// an API key for an authenticated endpoint.
+func NewWithHeaders(headers map[string]string, options ...func(*Client)) *Client {
+	return New(append([]func(*Client){func(c *Client) {
+		for k, v := range headers {
+			c.header[k] = v
+		}
+	}}, options...)...)
+}
+
+// NewWithRedirectPolicy creates a new client for HTTP downloads which follows at most max
+// redirects, and only those for which the allow function returns true (e.g. to forbid the
+// redirects to private networks). A max of zero disables the redirects and a nil allow
+// function allows every location.
+func NewWithRedirectPolicy(max int, allow func(*stdhttp.Request) bool, options ...func(*Client)) *Client {
+	c := New(options...)
+	c.req.Client().CheckRedirect = func(r *stdhttp.Request, via []*stdhttp.Request) error {
+		switch {
+		case len(via) > max:
+			return fmt.Errorf("%w: %s", ErrTooManyRedirects, r.URL)
+		case allow != nil && !allow(r):
+			return fmt.Errorf("%w: %s", ErrRedirectBlocked, r.URL)
+		default:
+			return nil
+		}
+	}
+	return c
+}
+
+// WithUserAgent sets the 'User-Agent' header sent with every request, so the traffic can be
+// attributed to a named service.
+func WithUserAgent(ua string) func(*Client) {
+	return func(c *Client) {
+		c.header["User-Agent"] = ua
+	}
+}
+
+// WithAuthTokenFunc sets the function returning the bearer token sent in the 'Authorization'
+// header. It is invoked before every request, so that short-lived tokens can be rotated. The
+// request fails if no token can be obtained.
+func WithAuthTokenFunc(fn func() (string, error)) func(*Client) {
+	return func(c *Client) {
+		c.authToken = fn
+	}
+}
+
+// WithAuthToken sets a static bearer token sent in the 'Authorization' header, see
+// WithAuthTokenFunc for the tokens which rotate.
+func WithAuthToken(token string) func(*Client) {
+	return WithAuthTokenFunc(func() (string, error) {
+		return token, nil
+	})
+}
+
+// WithETagMatch treats a successful response carrying the same entity tag as the last download
+// as not modified, for the servers which ignore the conditional headers and always respond with
+// the full contents. This way, the unchanged resource is not reported as updated.
+func WithETagMatch() func(*Client) {
+	return func(c *Client) {
+		c.matchETag = true
+	}
 }
 
 // DownloadIf downloads a file only if the updatedSince time is older than the resource
 // timestamp itself.
 func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
-	resp, err := req.Head(uri, req.Header{
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata reported by the server along with its contents.
+// The query string of the URI (e.g. the signature of a presigned URL) is preserved on both the
+// HEAD and the GET requests. The entity tag of the version last seen by the caller, if carried
+// by the context with resource.LastSeen, is sent in the 'If-None-Match' header.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	header := req.Header{
 		"If-Modified-Since": updatedSince.Format(timeFormat),
-	})
+	}
+
+	// Use the entity tag last seen by the caller for conditional requests, if any
+	last := resource.LastSeenOf(ctx)
+	if last.ETag != "" && updatedSince.Unix() > 0 {
+		header["If-None-Match"] = last.ETag
+	}
+
+	resp, err := c.head(uri, header, ctx)
 	if err != nil {
-		return nil, err
+		return nil, resource.Meta{}, err
 	}
 
 	// If we got a 304 status code, it's not modified
 	if resp.Response().StatusCode == 304 {
-		return nil, nil
+		return nil, resource.Meta{}, nil
 	}
 
 	// Check for the 'Last-Modified' header
-	if lastMod := resp.Response().Header.Get("Last-Modified"); lastMod != "" {
-		if updatedAt, err := time.Parse(timeFormat, lastMod); err == nil {
-			if !isModified(updatedAt, updatedSince) {
-				return nil, nil
-			}
-		}
+	if updatedAt, ok := lastModified(resp.Response()); ok && !isModified(updatedAt, updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	// Servers ignoring the conditional headers may still report the same entity tag
+	if c.isUnchanged(resp.Response().Header.Get("ETag"), last.ETag, updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	b, meta, err := c.download(ctx, uri)
+	switch {
+	case err != nil:
+		return nil, resource.Meta{}, err
+	case c.isUnchanged(meta.ETag, last.ETag, updatedSince):
+		return nil, resource.Meta{}, nil
 	}
+	return b, meta, nil
+}
 
-	return c.Download(uri)
+// isUnchanged returns whether the entity tag reported by the server is the one last seen by the
+// caller, if the entity tags are matched and the resource was loaded before.
+func (c *Client) isUnchanged(etag, lastETag string, updatedSince time.Time) bool {
+	return c.matchETag && etag != "" && etag == lastETag && updatedSince.Unix() > 0
 }
 
 // Download simply downloads a file using an HTTP GET request.
 func (c *Client) Download(uri string) ([]byte, error) {
-	resp, err := req.Get(uri)
+	b, _, err := c.download(context.Background(), uri)
+	return b, err
+}
+
+// Fingerprint returns the entity tag of the file using an HTTP HEAD request. If the server
+// does not provide an entity tag, an empty string is returned.
+func (c *Client) Fingerprint(ctx context.Context, uri string) (string, error) {
+	resp, err := c.head(uri, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Response().Header.Get("ETag"), nil
+}
+
+// Exists checks whether the file exists using an HTTP HEAD request. A 404 or a 410 response
+// means the file does not exist, while any other unsuccessful response is an HTTPStatusError.
+func (c *Client) Exists(ctx context.Context, uri string) (bool, error) {
+	resp, err := c.head(uri, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	err = statusOf(resp.Response())
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// Stat returns the metadata of the file reported by the server using an HTTP HEAD request.
+// The size is only populated if the server reports the 'Content-Length' header.
+func (c *Client) Stat(ctx context.Context, uri string) (resource.Meta, error) {
+	resp, err := c.head(uri, ctx)
+	if err != nil {
+		return resource.Meta{}, err
+	}
+
+	if err := statusOf(resp.Response()); err != nil {
+		return resource.Meta{}, err
+	}
+
+	meta := metaOf(resp.Response())
+	meta.Size = max(meta.Size, 0)
+	return meta, nil
+}
+
+// Stream downloads a file using an HTTP GET request and returns the response body without
+// buffering it.
+func (c *Client) Stream(ctx context.Context, uri string) (io.ReadCloser, error) {
+	resp, err := c.get(uri, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.ToBytes()
+	return resp.Response().Body, nil
+}
+
+// DownloadTo downloads a file using an HTTP GET request into the writer and returns the
+// number of bytes written.
+func (c *Client) DownloadTo(ctx context.Context, uri string, w io.Writer) (int64, error) {
+	r, err := c.Stream(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// DownloadHead downloads the first n bytes of a file using a ranged HTTP GET request. If the
+// server does not support ranges, the remainder of the response is discarded.
+func (c *Client) DownloadHead(ctx context.Context, uri string, n int64) ([]byte, error) {
+	resp, err := c.get(uri, ctx, req.Header{
+		"Range": fmt.Sprintf("bytes=0-%d", n-1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := resp.Response().Body
+	defer body.Close()
+	return ioutil.ReadAll(io.LimitReader(body, n))
+}
+
+// DownloadTail downloads the last n bytes of a file using a ranged HTTP GET request. If the
+// server does not support ranges, the file is downloaded entirely and then truncated.
+func (c *Client) DownloadTail(ctx context.Context, uri string, n int64) ([]byte, error) {
+	resp, err := c.get(uri, ctx, req.Header{
+		"Range": fmt.Sprintf("bytes=-%d", n),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := resp.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Response().StatusCode != stdhttp.StatusPartialContent && int64(len(b)) > n {
+		b = b[int64(len(b))-n:]
+	}
+	return b, nil
+}
+
+// DownloadRange downloads length bytes of a file starting at the offset using a ranged HTTP
+// GET request. If the server does not support ranges, the file is downloaded entirely and
+// then sliced.
+func (c *Client) DownloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	resp, err := c.get(uri, ctx, req.Header{
+		"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := resp.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Response().StatusCode != stdhttp.StatusPartialContent {
+		offset = min(offset, int64(len(b)))
+		b = b[offset:min(offset+length, int64(len(b)))]
+	}
+	return b, nil
+}
+
+// download downloads a file using an HTTP GET request, along with its metadata. If the size
+// of the file is limited by the context, the body is read up to that limit only.
+func (c *Client) download(ctx context.Context, uri string) ([]byte, resource.Meta, error) {
+	resp, err := c.get(uri, ctx)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	body := resp.Response().Body
+	defer body.Close()
+	if err := resource.CheckSize(ctx, resp.Response().ContentLength); err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	b, err := readAll(ctx, body)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	meta := metaOf(resp.Response())
+	meta.Size = int64(len(b))
+	return b, meta, nil
+}
+
+// readAll reads the body entirely, or returns ErrTooLarge as soon as it exceeds the maximum
+// size allowed by the context.
+func readAll(ctx context.Context, body io.Reader) ([]byte, error) {
+	limit := resource.MaxSizeOf(ctx)
+	if limit <= 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	return b, resource.CheckSize(ctx, int64(len(b)))
+}
+
+// setClient sets the underlying HTTP client, with the transport configured by the options. The
+// client is copied rather than modified, since it may be shared with other code.
+func (c *Client) setClient(client *stdhttp.Client) {
+	configured := *client
+	if c.rawEncoding {
+		configured.Transport = withoutDecompression(configured.Transport)
+	}
+	if c.denyPrivate {
+		configured.Transport = guard(configured.Transport)
+	}
+
+	c.req.SetClient(&configured)
+}
+
+// withoutDecompression returns a copy of the transport which neither requests nor decodes the
+// compressed responses. Custom transports are returned as is, since they control the encoding.
+func withoutDecompression(rt stdhttp.RoundTripper) stdhttp.RoundTripper {
+	if rt == nil {
+		rt = stdhttp.DefaultTransport
+	}
+
+	transport, ok := rt.(*stdhttp.Transport)
+	if !ok {
+		return rt
+	}
+
+	transport = transport.Clone()
+	transport.DisableCompression = true
+	return transport
+}
+
+// head sends an HTTP HEAD request with the headers of the client
+func (c *Client) head(uri string, v ...interface{}) (*req.Resp, error) {
+	header, err := c.headerOf()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.req.Head(uri, append(v, header)...)
+}
+
+// get sends an HTTP GET request with the headers of the client and returns an HTTPStatusError
+// if the response is not successful, in which case the body of the response is discarded.
+func (c *Client) get(uri string, v ...interface{}) (*req.Resp, error) {
+	header, err := c.headerOf()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.req.Get(uri, append(v, header)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := statusOf(resp.Response()); err != nil {
+		resp.Response().Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// headerOf returns the headers of a request, along with a fresh bearer token if required
+func (c *Client) headerOf() (req.Header, error) {
+	if c.authToken == nil {
+		return c.header, nil
+	}
+
+	token, err := c.authToken()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the auth token: %w", err)
+	}
+
+	header := maps.Clone(c.header)
+	header["Authorization"] = "Bearer " + token
+	return header, nil
+}
+
+// statusOf returns an HTTPStatusError if the response is not successful
+func statusOf(resp *stdhttp.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPStatusError{
+			Code:       resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: retryAfter(resp),
+		}
+	}
+	return nil
+}
+
+// retryAfter parses the 'Retry-After' header of a throttled or unavailable response, given
+// either in seconds or as an HTTP date.
+func retryAfter(resp *stdhttp.Response) time.Duration {
+	switch resp.StatusCode {
+	case stdhttp.StatusTooManyRequests, stdhttp.StatusServiceUnavailable:
+	default:
+		return 0
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if at, err := stdhttp.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// metaOf returns the metadata from the response headers
+func metaOf(resp *stdhttp.Response) resource.Meta {
+	updatedAt, _ := lastModified(resp)
+	return resource.Meta{
+		LastModified:    updatedAt,
+		Size:            resp.ContentLength,
+		ETag:            resp.Header.Get("ETag"),
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
+	}
+}
+
+// lastModified parses the 'Last-Modified' header of the response, if present
+func lastModified(resp *stdhttp.Response) (time.Time, bool) {
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		if updatedAt, err := time.Parse(timeFormat, lastMod); err == nil {
+			return updatedAt, true
+		}
+	}
+
+	return time.Time{}, false
 }
 
+// isModified compares the times with a second granularity, since this is the precision of
+// the 'Last-Modified' header.
 func isModified(updatedAt, updatedSince time.Time) bool {
 	return updatedAt.UTC().Unix() > updatedSince.UTC().Unix()
 }
@@ -4,10 +4,29 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/kelindar/loader/resource"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +40,630 @@ func TestHTTP(t *testing.T) {
 	assert.Nil(t, b)
 	assert.NoError(t, err)
 }
+
+func TestHTTPMeta(t *testing.T) {
+	updatedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Header().Set("Last-Modified", updatedAt.Format(timeFormat))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	client := New()
+
+	{ // Modified since
+		b, meta, err := client.DownloadMeta(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, `{}`, string(b))
+		assert.Equal(t, updatedAt, meta.LastModified.UTC())
+		assert.Equal(t, int64(2), meta.Size)
+		assert.Equal(t, `"v1"`, meta.ETag)
+		assert.Equal(t, "application/json", meta.ContentType)
+	}
+
+	{ // Not modified since
+		b, _, err := client.DownloadMeta(context.Background(), ts.URL, time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+}
+
+func TestHTTPETag(t *testing.T) {
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		current := etag.Load().(string)
+		w.Header().Set("ETag", current)
+		if r.Header.Get("If-None-Match") == current {
+			w.WriteHeader(stdhttp.StatusNotModified)
+			return
+		}
+
+		w.Write([]byte("hello " + current))
+	}))
+	defer ts.Close()
+
+	client := New()
+
+	// Unconditional download
+	b, last, err := client.DownloadMeta(context.Background(), ts.URL, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, `hello "v1"`, string(b))
+	assert.Equal(t, `"v1"`, last.ETag)
+
+	{ // Same entity tag, not modified
+		b, _, err := client.DownloadMeta(resource.LastSeen(context.Background(), last), ts.URL, time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Without the entity tag of the caller, downloaded again
+		b, _, err := client.DownloadMeta(context.Background(), ts.URL, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, `hello "v1"`, string(b))
+	}
+
+	{ // Different entity tag, modified
+		etag.Store(`"v2"`)
+		b, _, err := client.DownloadMeta(resource.LastSeen(context.Background(), last), ts.URL, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, `hello "v2"`, string(b))
+	}
+}
+
+func TestHTTPETagMatch(t *testing.T) {
+	var etag atomic.Value
+	var gets int64
+	etag.Store(`"v1"`)
+
+	// The server ignores the conditional headers and always responds with 200
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		if r.Method == stdhttp.MethodGet {
+			atomic.AddInt64(&gets, 1)
+		}
+
+		current := etag.Load().(string)
+		w.Header().Set("ETag", current)
+		w.Write([]byte("hello " + current))
+	}))
+	defer ts.Close()
+
+	for _, matched := range []bool{true, false} {
+		client := New()
+		if matched {
+			client = New(WithETagMatch())
+		}
+
+		etag.Store(`"v1"`)
+		atomic.StoreInt64(&gets, 0)
+		b, last, err := client.DownloadMeta(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, `hello "v1"`, string(b))
+
+		// Same entity tag, not modified only if matched
+		ctx := resource.LastSeen(context.Background(), last)
+		b, _, err = client.DownloadMeta(ctx, ts.URL, time.Now())
+		assert.NoError(t, err)
+		if matched {
+			assert.Nil(t, b)
+			assert.Equal(t, int64(1), atomic.LoadInt64(&gets))
+		} else {
+			assert.Equal(t, `hello "v1"`, string(b))
+		}
+
+		// Different entity tag, modified
+		etag.Store(`"v2"`)
+		b, _, err = client.DownloadMeta(ctx, ts.URL, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, `hello "v2"`, string(b))
+	}
+}
+
+func TestHTTPHeadTail(t *testing.T) {
+	var ranges []string
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		stdhttp.ServeContent(w, r, "test.txt", time.Now(), bytes.NewReader([]byte("hello world")))
+	}))
+	defer ts.Close()
+
+	client := New()
+
+	{ // Head
+		b, err := client.DownloadHead(context.Background(), ts.URL, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	{ // Tail
+		b, err := client.DownloadTail(context.Background(), ts.URL, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+	}
+
+	{ // Range
+		b, err := client.DownloadRange(context.Background(), ts.URL, 3, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "lo wo", string(b))
+	}
+
+	assert.Equal(t, []string{"bytes=0-4", "bytes=-5", "bytes=3-7"}, ranges)
+}
+
+func TestHTTPStream(t *testing.T) {
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	r, err := New().Stream(context.Background(), ts.URL)
+	assert.NoError(t, err)
+
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestHTTPFingerprint(t *testing.T) {
+	etag := `"v1"`
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Header().Set("ETag", etag)
+	}))
+	defer ts.Close()
+
+	client := New()
+	fp, err := client.Fingerprint(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, `"v1"`, fp)
+
+	etag = `"v2"`
+	fp, err = client.Fingerprint(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, `"v2"`, fp)
+}
+
+func TestHTTPWithClient(t *testing.T) {
+	ts := httptest.NewTLSServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	{ // Unknown certificate authority
+		b, err := New().DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.Error(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Trusted with a custom pool
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+		client := NewWithClient(&stdhttp.Client{
+			Transport: &stdhttp.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		})
+
+		b, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+	}
+}
+
+func TestHTTPTLSConfig(t *testing.T) {
+	cert, parsed := newCertificate(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	// The server requires a client certificate signed by the pool
+	ts := httptest.NewUnstartedServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	{ // Without the client certificate
+		client := NewWithTLSConfig(&tls.Config{RootCAs: roots})
+		_, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.Error(t, err)
+	}
+
+	{ // With the client certificate
+		client := NewWithTLSConfig(&tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert},
+		})
+
+		b, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+	}
+}
+
+// newCertificate creates a self-signed client certificate
+func newCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	parsed, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, parsed
+}
+
+func TestHTTPHeadTailNoRange(t *testing.T) {
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	client := New()
+
+	{ // Head
+		b, err := client.DownloadHead(context.Background(), ts.URL, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	{ // Tail
+		b, err := client.DownloadTail(context.Background(), ts.URL, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(b))
+	}
+}
+
+func TestHTTPRangeNoRange(t *testing.T) {
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	b, err := New().DownloadRange(context.Background(), ts.URL, 3, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "lo wo", string(b))
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	for _, code := range []int{stdhttp.StatusNotFound, stdhttp.StatusInternalServerError} {
+		ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			w.WriteHeader(code)
+			w.Write([]byte("error page"))
+		}))
+
+		b, err := New().DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		ts.Close()
+
+		var statusErr *HTTPStatusError
+		assert.Nil(t, b)
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, code, statusErr.Code)
+		assert.Equal(t, code == stdhttp.StatusNotFound, errors.Is(err, fs.ErrNotExist))
+	}
+}
+
+func TestHTTPRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		code     int
+		header   string
+		min, max time.Duration
+	}{
+		{code: stdhttp.StatusTooManyRequests, header: "3", min: 3 * time.Second, max: 3 * time.Second},
+		{code: stdhttp.StatusServiceUnavailable, header: time.Now().Add(5 * time.Second).UTC().Format(stdhttp.TimeFormat), min: 3 * time.Second, max: 5 * time.Second},
+		{code: stdhttp.StatusServiceUnavailable, header: "invalid"},
+		{code: stdhttp.StatusTooManyRequests, header: time.Now().Add(-time.Hour).UTC().Format(stdhttp.TimeFormat)},
+		{code: stdhttp.StatusInternalServerError, header: "3"},
+	} {
+		ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			w.Header().Set("Retry-After", tc.header)
+			w.WriteHeader(tc.code)
+		}))
+
+		_, err := New().DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		ts.Close()
+
+		var statusErr *HTTPStatusError
+		assert.True(t, errors.As(err, &statusErr), tc.header)
+		assert.GreaterOrEqual(t, statusErr.RetryAfter, tc.min, tc.header)
+		assert.LessOrEqual(t, statusErr.RetryAfter, tc.max, tc.header)
+	}
+}
+
+func TestHTTPHeaders(t *testing.T) {
+	var methods []string
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(stdhttp.StatusUnauthorized)
+			return
+		}
+
+		methods = append(methods, r.Method)
+		switch r.Header.Get("Accept") {
+		case "application/json":
+			w.Write([]byte(`{"hello":"world"}`))
+		default:
+			w.Write([]byte("hello world"))
+		}
+	}))
+	defer ts.Close()
+
+	for accept, expect := range map[string]string{
+		"application/json": `{"hello":"world"}`,
+		"text/plain":       "hello world",
+	} {
+		methods = nil
+		client := NewWithHeaders(map[string]string{
+			"Accept":    accept,
+			"X-API-Key": "secret",
+		})
+
+		b, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expect, string(b))
+		assert.Equal(t, []string{"HEAD", "GET"}, methods)
+	}
+
+	// Without the headers, the request is not authorized
+	_, err := New().DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+	assert.Error(t, err)
+}
+
+func TestHTTPAuthToken(t *testing.T) {
+	var tokens []string
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(token, "token-") {
+			w.WriteHeader(stdhttp.StatusUnauthorized)
+			return
+		}
+
+		tokens = append(tokens, token)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	{ // Static token
+		tokens = nil
+		b, err := New(WithAuthToken("token-static")).DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, []string{"token-static", "token-static"}, tokens)
+	}
+
+	{ // Rotating token, fetched before every request
+		tokens = nil
+		var count int
+		client := New(WithAuthTokenFunc(func() (string, error) {
+			count++
+			return fmt.Sprintf("token-%d", count), nil
+		}))
+
+		b, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, []string{"token-1", "token-2"}, tokens)
+	}
+
+	{ // No token available
+		tokens = nil
+		client := New(WithAuthTokenFunc(func() (string, error) {
+			return "", errors.New("expired")
+		}))
+
+		_, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.ErrorContains(t, err, "expired")
+		assert.Empty(t, tokens)
+	}
+
+	{ // No token at all
+		_, err := New().DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.Error(t, err)
+	}
+}
+
+func TestHTTPPresigned(t *testing.T) {
+	var methods []string
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		if r.URL.Query().Get("X-Amz-Signature") != "abc/123=" || r.URL.Query().Get("X-Amz-Expires") != "60" {
+			w.WriteHeader(stdhttp.StatusForbidden)
+			return
+		}
+
+		methods = append(methods, r.Method)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	client := New()
+
+	{ // The signature is carried by both the HEAD and the GET
+		b, err := client.DownloadIf(context.Background(), ts.URL+"/key?X-Amz-Expires=60&X-Amz-Signature=abc%2F123%3D", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, []string{stdhttp.MethodHead, stdhttp.MethodGet}, methods)
+	}
+
+	{ // Without the signature, the request is rejected
+		b, err := client.DownloadIf(context.Background(), ts.URL+"/key", time.Unix(0, 0))
+		assert.Nil(t, b)
+		assert.Error(t, err)
+	}
+}
+
+func TestHTTPUserAgent(t *testing.T) {
+	var agents []string
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		agents = append(agents, r.Method+" "+r.UserAgent())
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	b, err := New(WithUserAgent("my-service/1.0")).DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, []string{"HEAD my-service/1.0", "GET my-service/1.0"}, agents)
+}
+
+func TestHTTPRedirectPolicy(t *testing.T) {
+	// Redirects /3 to /2, /1 and finally /0 which serves the contents
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if n > 0 {
+			stdhttp.Redirect(w, r, "/"+strconv.Itoa(n-1), stdhttp.StatusFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	{ // Within the limit
+		b, err := NewWithRedirectPolicy(3, nil).Download(ts.URL + "/3")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	{ // Exceeds the limit
+		_, err := NewWithRedirectPolicy(2, nil).Download(ts.URL + "/3")
+		assert.ErrorIs(t, err, ErrTooManyRedirects)
+	}
+
+	{ // Redirects disabled
+		_, err := NewWithRedirectPolicy(0, nil).DownloadIf(context.Background(), ts.URL+"/1", time.Unix(0, 0))
+		assert.ErrorIs(t, err, ErrTooManyRedirects)
+	}
+
+	{ // Blocked by the allow function
+		var visited []string
+		client := NewWithRedirectPolicy(10, func(r *stdhttp.Request) bool {
+			visited = append(visited, r.URL.Path)
+			return r.URL.Path != "/1"
+		})
+
+		_, err := client.Download(ts.URL + "/3")
+		assert.ErrorIs(t, err, ErrRedirectBlocked)
+		assert.Equal(t, []string{"/2", "/1"}, visited)
+	}
+}
+
+func TestHTTPTransportDecompression(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("hello world"))
+	gz.Close()
+
+	// Serves a pre-compressed artifact, regardless of the encodings accepted
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer ts.Close()
+
+	{ // Decompressed by the transport
+		b, err := NewWithTransportDecompression(true).DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+	}
+
+	{ // Raw bytes as stored
+		b, err := NewWithTransportDecompression(false).DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, compressed.Bytes(), b)
+	}
+
+	{ // Raw bytes with a custom client
+		client := NewWithClient(&stdhttp.Client{}, func(c *Client) { c.rawEncoding = true })
+		b, err := client.DownloadIf(context.Background(), ts.URL, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, compressed.Bytes(), b)
+	}
+}
+
+func TestHTTPExists(t *testing.T) {
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		switch r.URL.Path {
+		case "/found":
+			w.Write([]byte("hello"))
+		case "/error":
+			w.WriteHeader(stdhttp.StatusInternalServerError)
+		default:
+			w.WriteHeader(stdhttp.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client := New()
+	for path, expect := range map[string]bool{"/found": true, "/missing": false} {
+		ok, err := client.Exists(context.Background(), ts.URL+path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expect, ok, path)
+	}
+
+	ok, err := client.Exists(context.Background(), ts.URL+"/error")
+	assert.False(t, ok)
+	assert.Error(t, err)
+}
+
+func TestHTTPStat(t *testing.T) {
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		if r.URL.Path != "/found" {
+			w.WriteHeader(stdhttp.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		stdhttp.ServeContent(w, r, "test.txt", modified, bytes.NewReader([]byte("hello")))
+	}))
+	defer ts.Close()
+
+	meta, err := New().Stat(context.Background(), ts.URL+"/found")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), meta.Size)
+	assert.Equal(t, modified, meta.LastModified)
+	assert.Equal(t, `"v1"`, meta.ETag)
+	assert.Equal(t, "text/plain; charset=utf-8", meta.ContentType)
+
+	_, err = New().Stat(context.Background(), ts.URL+"/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestHTTPCancel(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	b, err := New().DownloadIf(ctx, ts.URL, time.Unix(0, 0))
+	assert.Nil(t, b)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,22 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package http_test
+
+import (
+	"github.com/kelindar/loader"
+	"github.com/kelindar/loader/http"
+)
+
+// Make sure the client implements the capabilities of the loader
+var (
+	_ loader.Downloader        = (*http.Client)(nil)
+	_ loader.MetaDownloader    = (*http.Client)(nil)
+	_ loader.Streamer          = (*http.Client)(nil)
+	_ loader.PartialDownloader = (*http.Client)(nil)
+	_ loader.RangeDownloader   = (*http.Client)(nil)
+	_ loader.Fingerprinter     = (*http.Client)(nil)
+	_ loader.WriterDownloader  = (*http.Client)(nil)
+	_ loader.Exister           = (*http.Client)(nil)
+	_ loader.Stater            = (*http.Client)(nil)
+)
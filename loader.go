@@ -4,20 +4,59 @@
 package loader
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/kelindar/loader/data"
 	"github.com/kelindar/loader/file"
 	"github.com/kelindar/loader/http"
+	"github.com/kelindar/loader/resource"
+	"golang.org/x/time/rate"
 )
 
 var (
-	zeroTime = time.Unix(0, 0)
-	timeout  = 30 * time.Second
+	zeroTime    = time.Unix(0, 0)
+	timeout     = 30 * time.Second
+	concurrency = 8
+)
+
+var (
+	// ErrUnsupportedScheme is returned when no downloader is registered for the scheme of a URI
+	ErrUnsupportedScheme = errors.New("scheme is not supported")
+
+	// ErrUnsupportedListing is returned when the downloader is unable to list the resources
+	ErrUnsupportedListing = errors.New("listing is not supported")
+
+	// ErrUnsupportedUpload is returned when the downloader is unable to upload the resources
+	ErrUnsupportedUpload = errors.New("upload is not supported")
+
+	// ErrChecksumMismatch is returned when the hash of a payload differs from the expected one
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrDecode is returned when a payload was loaded but could not be decoded
+	ErrDecode = errors.New("unable to decode")
+
+	// ErrNotFound is returned by the watchers when the resource is missing on the first check
+	ErrNotFound = errors.New("resource not found")
+
+	// ErrPanic is reported by the watchers when a check panics, for example in a downloader
+	ErrPanic = errors.New("panic recovered")
+
+	// ErrTooLarge is returned when a resource exceeds the maximum size set with WithMaxSize
+	ErrTooLarge = resource.ErrTooLarge
 )
 
 // Downloader represents a downloader client (e.g. s3, gcs)
@@ -25,10 +64,143 @@ type Downloader interface {
 	DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error)
 }
 
+// Meta represents the metadata of a resource, such as its modification time or entity tag.
+type Meta = resource.Meta
+
+// MetaDownloader represents a downloader which is also able to return the metadata of
+// the resource alongside its contents.
+type MetaDownloader interface {
+	DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, Meta, error)
+}
+
+// Streamer represents a downloader which is able to stream the contents of the resource
+// instead of buffering it entirely in memory.
+type Streamer interface {
+	Stream(ctx context.Context, uri string) (io.ReadCloser, error)
+}
+
+// Fingerprinter represents a downloader which is able to return a strong validator of the
+// resource (e.g. an entity tag) without transferring its contents. If the backend has no such
+// validator, an empty string should be returned.
+type Fingerprinter interface {
+	Fingerprint(ctx context.Context, uri string) (string, error)
+}
+
+// PartialDownloader represents a downloader which is able to download only the first or
+// the last n bytes of a resource, without transferring it entirely.
+type PartialDownloader interface {
+	DownloadHead(ctx context.Context, uri string, n int64) ([]byte, error)
+	DownloadTail(ctx context.Context, uri string, n int64) ([]byte, error)
+}
+
+// RangeDownloader represents a downloader which is able to download a specific byte range
+// of a resource, without transferring it entirely.
+type RangeDownloader interface {
+	DownloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error)
+}
+
+// Lister represents a downloader which is able to enumerate the resources under a prefix.
+type Lister interface {
+	List(ctx context.Context, uri string) ([]string, error)
+}
+
+// stateHook represents a function invoked on the state transitions of the watchers
+type stateHook = func(uri string, from, to int32)
+
+// WriterDownloader represents a downloader which is able to download a resource directly into
+// a writer (e.g. a file), without buffering it entirely in memory.
+type WriterDownloader interface {
+	DownloadTo(ctx context.Context, uri string, w io.Writer) (int64, error)
+}
+
+// Exister represents a downloader which is able to check whether a resource exists without
+// transferring its contents.
+type Exister interface {
+	Exists(ctx context.Context, uri string) (bool, error)
+}
+
+// Stater represents a downloader which is able to retrieve the metadata of a resource without
+// transferring its contents.
+type Stater interface {
+	Stat(ctx context.Context, uri string) (Meta, error)
+}
+
+// LoadError is returned when a resource fails to load, so that the resource can be identified
+// when many of them are loaded. The underlying error is reachable with errors.Is and errors.As.
+type LoadError struct {
+	URI    string // The URI of the resource, as requested
+	Scheme string // The scheme of the URI, in lower case
+	Err    error  // The underlying error
+}
+
+// Error returns the error message
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("unable to load %s: %v", e.URI, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// Uploader represents a downloader which is also able to upload a resource, for example to
+// synchronize the resources across backends.
+type Uploader interface {
+	Upload(ctx context.Context, uri string, data []byte) error
+}
+
+// Decrypter represents a decrypter for payloads encrypted at rest (e.g. with age)
+type Decrypter interface {
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// Logger represents a logger for the internal errors of the loader, such as the panics
+// recovered in the watchers. The standard library *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Observer represents a hook which is notified about the activity of the loader, for example
+// to export metrics to Prometheus or OpenTelemetry.
+type Observer interface {
+	OnDownload(scheme string, bytes int, duration time.Duration, err error)
+	OnWatchEvent(uri string, changed bool)
+}
+
+// PostProcessor represents a function that transforms or validates the downloaded bytes.
+type PostProcessor func(uri string, data []byte) ([]byte, error)
+
 // Loader represents a client that can load something from a remote source.
 type Loader struct {
-	watchers sync.Map              // The list of watchers
-	clients  map[string]Downloader // The list of dowloaders
+	watchers sync.Map                // The list of watchers
+	clients  map[string]Downloader   // The list of dowloaders
+	process  []PostProcessor         // The list of post-processors
+	timeout  time.Duration           // The timeout for a single check of a watcher
+	dedup    bool                    // Whether watchers suppress updates with identical content
+	inflate  bool                    // Whether compressed payloads are decompressed
+	attempts int                     // The maximum number of download attempts
+	backoff  func(int) time.Duration // The delay before the next download attempt
+	logger   Logger                  // The logger for the internal errors
+	debounce time.Duration           // The quiet period before a change is emitted
+	jitter   float64                 // The fraction of the interval by which the checks are randomized
+	maxDelay time.Duration           // The maximum interval of a watcher backing off on errors
+	onState  stateHook               // The hook invoked on the state transitions of the watchers
+	maxSize  int64                   // The maximum size of a resource, in bytes
+	cache    *cache                  // The cache of the downloaded resources, if enabled
+	clock    Clock                   // The source of time of the watchers
+	workers  int                     // The maximum number of concurrent loads of LoadAll
+	buffer   int                     // The capacity of the update channel of the watchers
+	observer Observer                // The observer of the loader activity
+	required bool                    // Whether the first check of a watcher must load the resource
+	archives *archives               // The cache of archives, if archive members can be loaded
+	baseDir  string                  // The directory against which the relative paths are resolved
+	baseURI  string                  // The URI prefix to which the relative paths are joined
+	limits   limiters                // The rate limiters of the downloads, by scheme
+	exact    bool                    // Whether the keys of the objects are matched exactly
+	stagger  *stagger                // The spacing of the first checks of the watchers, if any
+	onPanic  func(string, any)       // The hook invoked when a check of a watcher panics
+	maxFails int                     // The consecutive failed checks after which a watcher stops
+	restart  time.Duration           // The delay before a watcher stopped on failures starts again
 }
 
 // New creates a new loader instance.
@@ -36,10 +208,17 @@ func New(options ...func(*Loader)) *Loader {
 	web := http.New()
 	loader := &Loader{
 		clients: map[string]Downloader{
+			"data":  data.New(),
 			"file":  file.New(),
 			"http":  web,
 			"https": web,
 		},
+		timeout:  timeout,
+		logger:   log.Default(),
+		buffer:   1,
+		observer: nopObserver{},
+		clock:    realClock{},
+		workers:  concurrency,
 	}
 
 	for _, option := range options {
@@ -54,47 +233,555 @@ func (l *Loader) Load(ctx context.Context, uri string) ([]byte, error) {
 	return l.LoadIf(ctx, uri, zeroTime)
 }
 
+// LoadInto attempts to load the resource from the specified URL and decodes it into the
+// value with the decode function (e.g. json.Unmarshal). Decoding errors wrap ErrDecode, so
+// they can be told apart from the download errors.
+func (l *Loader) LoadInto(ctx context.Context, uri string, v interface{}, decode func([]byte, interface{}) error) error {
+	b, err := l.Load(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	if err := decode(b, v); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrDecode, uri, err)
+	}
+	return nil
+}
+
+// LoadJSON attempts to load the resource from the specified URL and decodes it as JSON into
+// the value.
+func (l *Loader) LoadJSON(ctx context.Context, uri string, v interface{}) error {
+	return l.LoadInto(ctx, uri, v, json.Unmarshal)
+}
+
+// LoadAll loads the resources from the specified URLs concurrently, using up to 8 concurrent
+// loads by default (see WithConcurrency). The resources which were loaded are returned by URL,
+// even if some of them failed, in which case the errors are joined together.
+func (l *Loader) LoadAll(ctx context.Context, uris []string) (map[string][]byte, error) {
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, uri := range uris {
+			jobs <- uri
+		}
+	}()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	out := make(map[string][]byte, len(uris))
+	for i := 0; i < min(max(l.workers, 1), len(uris)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range jobs {
+				b, err := l.Load(ctx, uri)
+
+				lock.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					out[uri] = b
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return out, errors.Join(errs...)
+}
+
 // LoadIf attempts to load the resource from the specified URL but only if it's more recent
 // than the specified 'updatedSince' time.
 func (l *Loader) LoadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
-	u, err := url.Parse(uri)
+	b, _, err := l.LoadWithMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// LoadWithMeta attempts to load the resource from the specified URL but only if it's more
+// recent than the specified 'updatedSince' time, and returns the metadata of the resource.
+// If the downloader does not report metadata, only the size of the payload is populated. The
+// errors are wrapped in a LoadError which carries the URI of the resource.
+func (l *Loader) LoadWithMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, Meta, error) {
+	b, meta, err := l.load(ctx, uri, updatedSince)
+	if err != nil {
+		return nil, Meta{}, &LoadError{URI: uri, Scheme: schemeOf(uri), Err: err}
+	}
+	return b, meta, nil
+}
+
+// load loads the resource if modified, decompresses and post-processes it, see LoadWithMeta.
+func (l *Loader) load(ctx context.Context, uri string, updatedSince time.Time) ([]byte, Meta, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+
+	// Download the payload, if modified
+	ctx = l.contextOf(ctx)
+	if l.maxSize > 0 {
+		ctx = resource.LimitSize(ctx, l.maxSize)
+	}
+
+	start := time.Now()
+	b, meta, err := l.fetch(ctx, client, uri, updatedSince)
+	if err == nil {
+		err = resource.CheckSize(ctx, int64(len(b)))
+	}
+
+	l.observer.OnDownload(schemeOf(uri), len(b), time.Since(start), err)
+	if err != nil || b == nil {
+		return nil, Meta{}, err
+	}
+
+	// Decompress the payload, if required
+	if l.inflate {
+		if b, err = decompress(uri, meta, b, l.maxSize); err != nil {
+			return nil, Meta{}, err
+		}
+	}
+
+	// Post-process the payload
+	if b, err = l.postProcess(uri, b); err != nil {
+		return nil, Meta{}, err
+	}
+	return b, meta, nil
+}
+
+// Stream opens a stream to read the resource from the specified URL. If the downloader does
+// not support streaming, the resource is downloaded entirely and wrapped in a reader instead.
+// The caller is responsible for closing the returned reader. Post-processors are not applied
+// to streams.
+func (l *Loader) Stream(ctx context.Context, uri string) (io.ReadCloser, error) {
+	client, uri, err := l.clientOf(uri)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get the client for the scheme and download
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(Streamer); ok {
+		return dl.Stream(ctx, uri)
+	}
+
+	b, err := client.DownloadIf(ctx, uri, zeroTime)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// CopyTo copies the resource from the specified URL into the destination writer without
+// buffering it entirely in memory, and returns the number of bytes written.
+func (l *Loader) CopyTo(ctx context.Context, uri string, dst io.Writer) (int64, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(WriterDownloader); ok {
+		return dl.DownloadTo(ctx, uri, dst)
+	}
+
+	r, err := l.Stream(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+	return io.Copy(dst, r)
+}
+
+// Fingerprint returns a stable fingerprint of the resource from the specified URL which
+// changes whenever its contents change. When possible, this uses the validator provided by
+// the backend, otherwise the resource is downloaded and hashed.
+func (l *Loader) Fingerprint(ctx context.Context, uri string) (string, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return "", err
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(Fingerprinter); ok {
+		if v, err := dl.Fingerprint(ctx, uri); err != nil || v != "" {
+			return v, err
+		}
+	}
+
+	b, err := client.DownloadIf(ctx, uri, zeroTime)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// LoadHead loads the first n bytes of the resource from the specified URL. Where the
+// downloader supports it, only the requested portion of the resource is transferred.
+// Post-processors are not applied to partial payloads.
+func (l *Loader) LoadHead(ctx context.Context, uri string, n int) ([]byte, error) {
+	client, uri, err := l.clientOf(uri)
+	switch {
+	case err != nil:
+		return nil, err
+	case n <= 0:
+		return []byte{}, nil
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(PartialDownloader); ok {
+		return dl.DownloadHead(ctx, uri, int64(n))
+	}
+
+	b, err := client.DownloadIf(ctx, uri, zeroTime)
+	if err != nil {
+		return nil, err
+	}
+	return b[:min(n, len(b))], nil
+}
+
+// LoadTail loads the last n bytes of the resource from the specified URL. Where the
+// downloader supports it, only the requested portion of the resource is transferred.
+// Post-processors are not applied to partial payloads.
+func (l *Loader) LoadTail(ctx context.Context, uri string, n int) ([]byte, error) {
+	client, uri, err := l.clientOf(uri)
+	switch {
+	case err != nil:
+		return nil, err
+	case n <= 0:
+		return []byte{}, nil
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(PartialDownloader); ok {
+		return dl.DownloadTail(ctx, uri, int64(n))
+	}
+
+	b, err := client.DownloadIf(ctx, uri, zeroTime)
+	if err != nil {
+		return nil, err
+	}
+	return b[len(b)-min(n, len(b)):], nil
+}
+
+// Exists checks whether the resource at the specified URL exists. Where the downloader supports
+// it, the contents of the resource are not transferred. A missing resource is reported as false
+// with no error, while any other failure is returned as an error.
+func (l *Loader) Exists(ctx context.Context, uri string) (bool, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return false, err
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(Exister); ok {
+		return dl.Exists(ctx, uri)
+	}
+
+	_, err = client.DownloadIf(ctx, uri, zeroTime)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// Stat returns the metadata of the resource at the specified URL, such as its size and its
+// modification time. Where the downloader supports it, the contents of the resource are not
+// transferred, otherwise the resource is downloaded and only its metadata is returned.
+func (l *Loader) Stat(ctx context.Context, uri string) (Meta, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(Stater); ok {
+		return dl.Stat(ctx, uri)
+	}
+
+	_, meta, err := download(ctx, client, uri, zeroTime)
+	return meta, err
+}
+
+// List returns the URIs of the resources under the prefix of the specified URL, which can then
+// be loaded individually. The exact semantics depend on the downloader, for example the file
+// system supports glob patterns and directories.
+func (l *Loader) List(ctx context.Context, uri string) ([]string, error) {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	dl, ok := client.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedListing, uri)
+	}
+
+	return dl.List(ctx, uri)
+}
+
+// Upload uploads the data as the resource at the specified URL, replacing it if it exists. The
+// downloader of the scheme must support uploads, otherwise ErrUnsupportedUpload is returned.
+func (l *Loader) Upload(ctx context.Context, uri string, data []byte) error {
+	client, uri, err := l.clientOf(uri)
+	if err != nil {
+		return err
+	}
+
+	dl, ok := client.(Uploader)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedUpload, uri)
+	}
+
+	return dl.Upload(ctx, uri, data)
+}
+
+// LoadRange loads length bytes of the resource from the specified URL, starting at the
+// offset. Where the downloader supports it, only the requested range of the resource is
+// transferred. Post-processors are not applied to partial payloads.
+func (l *Loader) LoadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	client, uri, err := l.clientOf(uri)
+	switch {
+	case err != nil:
+		return nil, err
+	case length <= 0 || offset < 0:
+		return []byte{}, nil
+	}
+
+	ctx = l.contextOf(ctx)
+
+	if dl, ok := client.(RangeDownloader); ok {
+		return dl.DownloadRange(ctx, uri, offset, length)
+	}
+
+	b, err := client.DownloadIf(ctx, uri, zeroTime)
+	if err != nil {
+		return nil, err
+	}
+
+	offset = min(offset, int64(len(b)))
+	return b[offset:min(offset+length, int64(len(b)))], nil
+}
+
+// DownloaderFor returns the downloader which handles the URL along with its normalized (lower
+// case) scheme, for example to validate a URL before loading it. Paths without a scheme are
+// handled by the 'file' downloader.
+func (l *Loader) DownloaderFor(uri string) (Downloader, string, error) {
+	uri, err := l.resolve(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Get the client for the scheme
 	scheme := strings.ToLower(u.Scheme)
-	if client, ok := l.clients[scheme]; ok {
-		return client.DownloadIf(ctx, uri, updatedSince)
+	client, ok := l.clients[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedScheme, u.Scheme)
+	}
+
+	return client, scheme, nil
+}
+
+// contextOf returns the context with which the resources are downloaded
+func (l *Loader) contextOf(ctx context.Context) context.Context {
+	if l.exact {
+		ctx = resource.ExactKeys(ctx)
+	}
+	return ctx
+}
+
+// clientOf returns the downloader registered for the scheme of the URL, along with the URL
+// itself, resolved if it is a path without a scheme
+func (l *Loader) clientOf(uri string) (Downloader, string, error) {
+	uri, err := l.resolve(uri)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return nil, fmt.Errorf("scheme %s is not supported", u.Scheme)
+	client, _, err := l.DownloaderFor(uri)
+	return client, uri, err
 }
 
-// Watch starts watching a specific URI
+// fetch downloads the resource, or the member of an archive if archives are supported
+func (l *Loader) fetch(ctx context.Context, client Downloader, uri string, updatedSince time.Time) ([]byte, Meta, error) {
+	if l.archives != nil {
+		if archiveURI, member, ok := splitArchive(uri); ok {
+			return l.loadMember(ctx, client, archiveURI, member, updatedSince)
+		}
+	}
+
+	if l.cache != nil {
+		return l.cache.load(ctx, uri, updatedSince, func(ctx context.Context, updatedSince time.Time) ([]byte, Meta, error) {
+			return l.downloadWithRetry(ctx, client, uri, updatedSince)
+		})
+	}
+
+	return l.downloadWithRetry(ctx, client, uri, updatedSince)
+}
+
+// schemeOf returns the scheme of the URL, in lower case
+func schemeOf(uri string) string {
+	scheme, _, _ := strings.Cut(uri, ":")
+	return strings.ToLower(scheme)
+}
+
+// download downloads the resource with its metadata, if the downloader supports it.
+func download(ctx context.Context, client Downloader, uri string, updatedSince time.Time) ([]byte, Meta, error) {
+	if dl, ok := client.(MetaDownloader); ok {
+		return dl.DownloadMeta(ctx, uri, updatedSince)
+	}
+
+	b, err := client.DownloadIf(ctx, uri, updatedSince)
+	return b, Meta{Size: int64(len(b))}, err
+}
+
+// postProcess applies the post-processors, in the order they were registered.
+func (l *Loader) postProcess(uri string, data []byte) (out []byte, err error) {
+	out = data
+	for _, process := range l.process {
+		if out, err = process(uri, out); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Watch starts watching a specific URI and returns a channel of updates which is closed once
+// the context is cancelled or the watch is stopped by Unwatch. Watching the same URI again
+// shares the polling, with every update pushed out to each of the channels, and the last
+// update is replayed to the new channel. A non-positive interval defaults to 100ms.
 func (l *Loader) Watch(ctx context.Context, uri string, interval time.Duration) <-chan Update {
-	w, loaded := l.watchers.LoadOrStore(uri, newWatcher(l, uri, interval, func() {
-		l.Unwatch(uri)
-	}))
+	return l.watch(ctx, uri, interval, nil)
+}
 
-	// Start the watcher if it's a new one
-	watch := w.(*watcher)
-	if !loaded {
-		watch.Start(ctx)
+// watch subscribes to the watcher of the URI, creating it if required. The release function,
+// if any, is invoked once the channel of the subscriber is closed.
+func (l *Loader) watch(ctx context.Context, uri string, interval time.Duration, release func()) <-chan Update {
+	for {
+		created := newWatcher(l, uri, interval, nil)
+		created.onStop = func() {
+			l.watchers.CompareAndDelete(uri, created)
+		}
+
+		// Subscribe to the watcher and start it if it's a new one
+		w, loaded := l.watchers.LoadOrStore(uri, created)
+		watch := w.(*watcher)
+		if updates, ok := watch.subscribe(ctx, release); ok {
+			if !loaded {
+				watch.Start(ctx)
+			}
+			return updates
+		}
+
+		// The watcher was stopped in the meantime, replace it
+		l.watchers.CompareAndDelete(uri, watch)
 	}
-	return watch.updates
 }
 
-// Unwatch stops watching a specific URI
+// WatchFor starts watching a specific URI like Watch, but stops the watch automatically once
+// the maximum lifetime elapses, closing the channel. The watcher is unregistered unless the URI
+// is still watched by someone else, so that a transient watch does not leak.
+func (l *Loader) WatchFor(ctx context.Context, uri string, interval, maxLifetime time.Duration) <-chan Update {
+	ctx, cancel := context.WithTimeout(ctx, maxLifetime)
+	return l.watch(ctx, uri, interval, cancel)
+}
+
+// WatchFunc starts watching a specific URI and invokes the callback for every update on a
+// dedicated goroutine, one update at a time. The returned function stops the callback as
+// well as the underlying watcher, if it was started by this call.
+func (l *Loader) WatchFunc(ctx context.Context, uri string, interval time.Duration, fn func(Update)) (cancel func()) {
+	ctx, cancel = context.WithCancel(ctx)
+	updates := l.Watch(ctx, uri, interval)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u, ok := <-updates:
+				if !ok || ctx.Err() != nil {
+					return
+				}
+
+				fn(u)
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// WatchPrefix starts watching all of the resources under the prefix of the URI, which must be
+// supported by List, and returns a channel of updates identifying the key which was added or
+// modified. Every key present on the first check is emitted initially. The watch stops and the
+// channel is closed once the context is cancelled.
+func (l *Loader) WatchPrefix(ctx context.Context, uri string, interval time.Duration) <-chan KeyUpdate {
+	w := newPrefixWatcher(l, uri, interval)
+	go w.checkLoop(ctx)
+	return w.updates
+}
+
+// Unwatch stops the most recent watch of a specific URI and closes its channel. The URI is
+// polled until all of its watches are stopped, either by Unwatch or by cancelling their context.
 func (l *Loader) Unwatch(uri string) bool {
-	if v, loaded := l.watchers.LoadAndDelete(uri); loaded {
-		v.(*watcher).Close()
+	if v, ok := l.watchers.Load(uri); ok {
+		return v.(*watcher).unsubscribeLast()
+	}
+
+	return false
+}
+
+// Reload makes the watcher for a specific URI check for updates immediately, for example on
+// an external signal, and returns whether the URI is being watched.
+func (l *Loader) Reload(uri string) bool {
+	if v, ok := l.watchers.Load(uri); ok {
+		v.(*watcher).Reload()
+		return true
+	}
+
+	return false
+}
+
+// SetInterval changes the interval at which a specific URI is polled, for example to poll more
+// often during an incident, and returns whether the URI is being watched. The watcher keeps its
+// state, so the resource is not downloaded again unless it changes. A non-positive interval
+// defaults to 100ms.
+func (l *Loader) SetInterval(uri string, interval time.Duration) bool {
+	if v, ok := l.watchers.Load(uri); ok {
+		v.(*watcher).SetInterval(interval)
 		return true
 	}
 
 	return false
 }
 
+// WatcherStatus returns the status of the watcher for a specific URI, such as the time of
+// its last update and the most recent error, or false if the URI is not being watched.
+func (l *Loader) WatcherStatus(uri string) (WatcherStatus, bool) {
+	if v, ok := l.watchers.Load(uri); ok {
+		return v.(*watcher).Status(), true
+	}
+
+	return WatcherStatus{}, false
+}
+
 // RangeWatchers iterates over the currently active watchers by URL. If the
 // callback returns false, the iteration is halted.
 func (l *Loader) RangeWatchers(fn func(uri string) bool) {
@@ -113,6 +800,313 @@ func WithDownloader(scheme string, dl Downloader) func(*Loader) {
 	}
 }
 
+// WithSchemeAlias registers an alias for the downloader of the target scheme, for example to
+// load 's3a://' URIs with the 's3' downloader. Since options are applied in order, the target
+// scheme must be registered beforehand, otherwise the alias is ignored.
+func WithSchemeAlias(alias, target string) func(*Loader) {
+	return func(l *Loader) {
+		if dl, ok := l.clients[strings.ToLower(target)]; ok {
+			l.clients[strings.ToLower(alias)] = dl
+		}
+	}
+}
+
+// WithBaseDir sets the directory against which the relative paths without a scheme (e.g.
+// './config.json') are resolved, instead of the working directory.
+func WithBaseDir(dir string) func(*Loader) {
+	return func(l *Loader) {
+		l.baseDir = dir
+	}
+}
+
+// WithBasePrefix sets the URI prefix (e.g. 's3://bucket/env/prod/') to which the relative paths
+// without a scheme are joined, so 'config.json' loads 's3://bucket/env/prod/config.json'. The
+// relative paths can not escape the prefix, and the URIs with a scheme bypass it.
+func WithBasePrefix(prefix string) func(*Loader) {
+	return func(l *Loader) {
+		l.baseURI = prefix
+	}
+}
+
+// WithPostProcess registers a function which is invoked after every successful load and
+// is able to transform or reject the payload. Post-processors are applied in order.
+func WithPostProcess(fn PostProcessor) func(*Loader) {
+	return func(l *Loader) {
+		l.process = append(l.process, fn)
+	}
+}
+
+// WithDecrypter registers a decrypter which transparently decrypts every successfully
+// loaded payload, so that the plaintext is returned.
+func WithDecrypter(d Decrypter) func(*Loader) {
+	return WithPostProcess(func(uri string, data []byte) ([]byte, error) {
+		return d.Decrypt(data)
+	})
+}
+
+// WithChecksum verifies the integrity of the downloaded payloads. The callback returns the
+// expected digest of the resource and the hash algorithm (sha256, sha512, sha1 or md5), or
+// false if the resource should not be verified. If the digest differs, ErrChecksumMismatch
+// is returned. The verification is registered as a post-processor.
+func WithChecksum(fn func(uri string) (expected []byte, algo string, ok bool)) func(*Loader) {
+	return WithPostProcess(func(uri string, data []byte) ([]byte, error) {
+		expected, algo, ok := fn(uri)
+		if !ok {
+			return data, nil
+		}
+
+		if err := verifyChecksum(uri, data, expected, algo); err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+}
+
+// WithTimeout sets the timeout which applies to every attempt of a watcher to fetch
+// the resource. By default, this is set to 30 seconds.
+func WithTimeout(d time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		l.timeout = d
+	}
+}
+
+// WithDedup makes the watchers compute a hash of every downloaded payload and suppress
+// the update if its contents are identical to the previously emitted one.
+func WithDedup() func(*Loader) {
+	return func(l *Loader) {
+		l.dedup = true
+	}
+}
+
+// WithDecompression transparently decompresses gzip and zstd payloads, based on the content
+// encoding reported by the backend or the extension of the resource (.gz, .zst).
+func WithDecompression() func(*Loader) {
+	return func(l *Loader) {
+		l.inflate = true
+	}
+}
+
+// WithRetry retries the downloads failing with a transient error, up to the specified number
+// of attempts. Only the network errors, the timeouts and the throttling or server errors (e.g.
+// HTTP 408, 429 or 5xx) are retried, while any other error fails right away. The backoff
+// function returns the delay after a given failed attempt, starting at 1, and defaults to an
+// exponential backoff starting at 100ms if nil.
+func WithRetry(attempts int, backoff func(attempt int) time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		if backoff == nil {
+			backoff = exponentialBackoff
+		}
+
+		l.attempts = attempts
+		l.backoff = backoff
+	}
+}
+
+// WithRateLimit limits the rate of the downloads of the scheme to r per second, allowing bursts
+// of up to burst downloads, so that many watchers polling aggressively do not exceed the request
+// quotas of a provider. The downloads wait for their turn, as long as the context allows. An
+// empty scheme sets a global limit shared by all of the schemes.
+func WithRateLimit(scheme string, r rate.Limit, burst int) func(*Loader) {
+	return func(l *Loader) {
+		if l.limits == nil {
+			l.limits = make(limiters)
+		}
+
+		l.limits[strings.ToLower(scheme)] = rate.NewLimiter(r, burst)
+	}
+}
+
+// WithExactKeys makes the object stores (e.g. S3 or GCS) always load the object with the exact
+// key of the URL, even if it ends with a '/' or a '*', instead of the latest object under that
+// prefix. Listing the resources under a prefix is not affected.
+func WithExactKeys() func(*Loader) {
+	return func(l *Loader) {
+		l.exact = true
+	}
+}
+
+// WithCache caches the downloaded resources in memory and serves them from the cache until the
+// time-to-live expires, so that loading the same resource repeatedly does not hit the backend
+// every time. Once expired, a resource is only downloaded again if it was modified. Up to 1024
+// resources are cached, evicting the least recently downloaded ones. Every load returns its own
+// copy of the contents, which the caller is free to modify.
+func WithCache(ttl time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		l.cache = newCache(ttl)
+	}
+}
+
+// WithMaxSize limits the size of the loaded resources to n bytes, so a large resource can not
+// exhaust the memory. Where the backend reports the size (e.g. the 'Content-Length' header or
+// the size of an object), it is checked before the contents are transferred. The limit also
+// applies to the decompressed payloads and the members of the archives, so that a small
+// compressed resource can not inflate without bound. Loading a larger resource returns
+// ErrTooLarge.
+func WithMaxSize(n int64) func(*Loader) {
+	return func(l *Loader) {
+		l.maxSize = n
+	}
+}
+
+// WithConcurrency sets the maximum number of resources loaded concurrently by LoadAll, which
+// is 8 by default.
+func WithConcurrency(n int) func(*Loader) {
+	return func(l *Loader) {
+		l.workers = n
+	}
+}
+
+// WithDebounce makes the watchers wait for a quiet period after detecting a change before
+// emitting it, so a burst of rapid changes is coalesced into a single update with the latest
+// contents. The quiet period restarts on every detected change. The initial update of a
+// watcher is emitted immediately.
+func WithDebounce(d time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		l.debounce = d
+	}
+}
+
+// WithJitter randomizes the interval of the watchers by up to the fraction of the interval in
+// either direction on every check, so many instances watching the same resource do not poll
+// the backend at the same time. The fraction is capped at 0.5, so the interval is never more
+// than halved.
+func WithJitter(fraction float64) func(*Loader) {
+	return func(l *Loader) {
+		l.jitter = min(max(fraction, 0), maxJitter)
+	}
+}
+
+// WithMaxBackoff makes the watchers back off exponentially on consecutive failed checks, by
+// doubling the interval on every failure up to the maximum of d, so a struggling backend is not
+// polled at full rate. The interval resets to its base on the first successful check.
+func WithMaxBackoff(d time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		l.maxDelay = d
+	}
+}
+
+// WithMaxFailures stops the watchers once n consecutive checks fail, closing their channels
+// after the update with the last error, rather than polling a broken resource forever. By
+// default, the watchers never stop on failures.
+func WithMaxFailures(n int) func(*Loader) {
+	return func(l *Loader) {
+		l.maxFails = n
+	}
+}
+
+// WithAutoRestart makes the watchers stopped by WithMaxFailures start again after the delay,
+// rather than closing their channels, so the subscribers keep receiving the updates once the
+// backend recovers. The delay doubles every time the watcher fails again right after a restart,
+// up to 64 times the delay, and resets once a check succeeds.
+func WithAutoRestart(after time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		l.restart = after
+	}
+}
+
+// WithUpdateBuffer sets the capacity of the update channel of the watchers, which is 1 by
+// default. When the consumer lags behind and the buffer is full, the oldest update is dropped.
+func WithUpdateBuffer(n int) func(*Loader) {
+	return func(l *Loader) {
+		l.buffer = n
+	}
+}
+
+// WithArchiveSupport allows loading a member of a zip or tar (optionally gzipped) archive,
+// using a '!' to separate the archive from the path of the member, for example with the
+// 's3://bucket/data.zip!config/app.json' URI. The latest version of every archive is cached
+// in memory, so loading several members only downloads the archive once. Up to 64 archives are
+// cached, evicting the least recently downloaded or validated ones.
+func WithArchiveSupport() func(*Loader) {
+	return func(l *Loader) {
+		l.archives = newArchives()
+	}
+}
+
+// WithStartupStagger delays the first check of every new watcher by d more than the previous
+// one, so that a service registering many watchers at once does not cause a spike of requests
+// on startup. Once the burst is over, the first check of a new watcher is not delayed. Watch
+// returns right away, with the first update arriving after the delay.
+func WithStartupStagger(d time.Duration) func(*Loader) {
+	return func(l *Loader) {
+		if d > 0 {
+			l.stagger = newStagger(d)
+		}
+	}
+}
+
+// WithInitialLoad makes the first check of every watcher emit an update with ErrNotFound if
+// the resource is missing or nothing was loaded, instead of staying silent.
+func WithInitialLoad(required bool) func(*Loader) {
+	return func(l *Loader) {
+		l.required = required
+	}
+}
+
+// WithStateHook registers a function which is invoked on every state transition of a watcher,
+// for example to log the lifecycle of the watchers. The states are 0 (created), 1 (running),
+// 2 (canceled) and 3 (disposed), matching the names reported by the watcher status.
+func WithStateHook(fn func(uri string, from, to int32)) func(*Loader) {
+	return func(l *Loader) {
+		l.onState = fn
+	}
+}
+
+// WithPanicHook registers a function which is invoked with the recovered value whenever a check
+// of a watcher panics, for example to detect a misbehaving downloader. Either way, the panic is
+// reported to the subscribers as an update with an ErrPanic error, and the watcher keeps going.
+func WithPanicHook(fn func(uri string, recovered any)) func(*Loader) {
+	return func(l *Loader) {
+		l.onPanic = fn
+	}
+}
+
+// WithClock sets the source of time of the watchers, which is the wall time by default. This
+// allows to trigger the checks deterministically, for example with a fake clock in tests.
+func WithClock(clock Clock) func(*Loader) {
+	return func(l *Loader) {
+		l.clock = clock
+	}
+}
+
+// WithObserver sets the observer which is notified on every download and on every check of
+// the watchers, for example to export metrics.
+func WithObserver(o Observer) func(*Loader) {
+	return func(l *Loader) {
+		l.observer = o
+	}
+}
+
+// WithLogger sets the logger for the internal errors of the loader, such as the panics
+// recovered in the watchers. By default, the standard logger is used.
+func WithLogger(logger Logger) func(*Loader) {
+	return func(l *Loader) {
+		l.logger = logger
+	}
+}
+
+// WithFile registers a downloader for the file protocol, replacing the default one, for
+// example to confine the reads to a root directory with file.NewWithRoot.
+func WithFile(dl Downloader) func(*Loader) {
+	return WithDownloader("file", dl)
+}
+
+// WithHTTP registers a downloader for both the HTTP and HTTPS protocols, replacing the
+// default one.
+func WithHTTP(dl Downloader) func(*Loader) {
+	return func(l *Loader) {
+		l.clients["http"] = dl
+		l.clients["https"] = dl
+	}
+}
+
+// WithTLSConfig registers a downloader for both the HTTP and HTTPS protocols which uses the TLS
+// configuration, for example to present a client certificate to the endpoints requiring mutual
+// TLS, replacing the default one.
+func WithTLSConfig(config *tls.Config) func(*Loader) {
+	return WithHTTP(http.NewWithTLSConfig(config))
+}
+
 // WithS3 registers a downloader for the S3 protocol
 func WithS3(dl Downloader) func(*Loader) {
 	return WithDownloader("s3", dl)
@@ -125,3 +1119,14 @@ func WithGCS(dl Downloader) func(*Loader) {
 		l.clients["gcs"] = dl
 	}
 }
+
+// WithAzure registers a downloader for the Azure Blob Storage protocol
+func WithAzure(dl Downloader) func(*Loader) {
+	return WithDownloader("az", dl)
+}
+
+// nopObserver is an observer which ignores all of the events
+type nopObserver struct{}
+
+func (nopObserver) OnDownload(string, int, time.Duration, error) {}
+func (nopObserver) OnWatchEvent(string, bool)                    {}
@@ -4,12 +4,27 @@
 package loader
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kelindar/loader/file"
+	loaderhttp "github.com/kelindar/loader/http"
+	"github.com/kelindar/loader/memory"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +49,420 @@ func TestLoadIf(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestUnsupportedScheme(t *testing.T) {
+	_, err := New().Load(context.Background(), "redis://localhost:6379/config")
+	assert.True(t, errors.Is(err, ErrUnsupportedScheme))
+	assert.Contains(t, err.Error(), "redis")
+}
+
+func TestLoadJSON(t *testing.T) {
+	var config struct {
+		Name    string `json:"name"`
+		Retries int    `json:"retries"`
+	}
+
+	loader := New()
+	assert.NoError(t, loader.LoadJSON(context.Background(), writeTestFile(t, `{"name":"app","retries":3}`), &config))
+	assert.Equal(t, "app", config.Name)
+	assert.Equal(t, 3, config.Retries)
+
+	{ // Decode error
+		err := loader.LoadJSON(context.Background(), writeTestFile(t, `{"name":`), &config)
+		assert.True(t, errors.Is(err, ErrDecode))
+	}
+
+	{ // Download error
+		err := loader.LoadJSON(context.Background(), "file:///"+filepath.Join(t.TempDir(), "missing.json"), &config)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrDecode))
+	}
+}
+
+func TestWithFile(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "app.json"), []byte("hello"), 0644))
+	loader := New(WithFile(file.NewWithRoot(root)))
+
+	b, err := loader.Load(context.Background(), "file:///app.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = loader.Load(context.Background(), "file:///../../../../etc/passwd")
+	assert.True(t, errors.Is(err, file.ErrOutsideRoot))
+}
+
+func TestDataURI(t *testing.T) {
+	b, err := New().Load(context.Background(), "data:application/json;base64,eyJoZWxsbyI6IndvcmxkIn0=")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hello":"world"}`, string(b))
+}
+
+func TestMemoryDownloader(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://config.json", []byte("hello"))
+	loader := New(WithDownloader("mem", mem))
+
+	modTime := time.Now().Add(-time.Hour)
+	mem.SetModTime("mem://config.json", modTime)
+
+	{ // Modified
+		b, err := loader.LoadIf(context.Background(), "mem://config.json", modTime.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	{ // Not modified
+		b, err := loader.LoadIf(context.Background(), "mem://config.json", modTime.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+}
+
+func TestList(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://data/a.json", []byte("a"))
+	loader := New(WithDownloader("mem", mem))
+
+	keys, err := loader.List(context.Background(), "mem://data/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mem://data/a.json"}, keys)
+
+	_, err = loader.List(context.Background(), "http://localhost/data/")
+	assert.True(t, errors.Is(err, ErrUnsupportedListing))
+}
+
+func TestPostProcessDecrypt(t *testing.T) {
+	url := writeTestFile(t, base64.StdEncoding.EncodeToString([]byte("hello world")))
+	loader := New(WithPostProcess(func(uri string, data []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(data))
+	}))
+
+	b, err := loader.Load(context.Background(), url)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestPostProcessReject(t *testing.T) {
+	url := writeTestFile(t, "not json")
+	loader := New(WithPostProcess(func(uri string, data []byte) ([]byte, error) {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("invalid json in %s", uri)
+		}
+		return data, nil
+	}))
+
+	{ // Rejected when loading
+		b, err := loader.Load(context.Background(), url)
+		assert.Nil(t, b)
+		assert.Error(t, err)
+	}
+
+	{ // Rejected when watching
+		u := <-loader.Watch(context.Background(), url, time.Second)
+		assert.Nil(t, u.Data)
+		assert.Error(t, u.Err)
+		assert.True(t, loader.Unwatch(url))
+	}
+}
+
+func writeTestFile(t *testing.T, content string) string {
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+	return "file:///" + f
+}
+
+func TestDecrypter(t *testing.T) {
+	url := writeTestFile(t, "aGVsbG8gd29ybGQ=")
+	loader := New(WithDecrypter(new(base64Decrypter)))
+
+	b, err := loader.Load(context.Background(), url)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+// base64Decrypter is a fake decrypter which simply decodes base64
+type base64Decrypter struct{}
+
+func (base64Decrypter) Decrypt(data []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(data))
+}
+
+func TestLoadWithMeta(t *testing.T) {
+	f, _ := filepath.Abs("loader.go")
+	url := "file:///" + f
+	fi, _ := os.Stat(f)
+
+	b, meta, err := New().LoadWithMeta(context.Background(), url, time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(b)), meta.Size)
+	assert.Equal(t, fi.ModTime(), meta.LastModified)
+}
+
+func TestLoadHeadTail(t *testing.T) {
+	url := writeTestFile(t, "hello world")
+	loader := New(WithDownloader("static", staticDownloader("hello world")))
+
+	for _, uri := range []string{url, "static://test"} {
+		head, err := loader.LoadHead(context.Background(), uri, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(head))
+
+		tail, err := loader.LoadTail(context.Background(), uri, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(tail))
+
+		mid, err := loader.LoadRange(context.Background(), uri, 3, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, "lo wo", string(mid))
+
+		empty, err := loader.LoadHead(context.Background(), uri, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, empty)
+	}
+}
+
+// staticDownloader always returns the same content
+type staticDownloader string
+
+func (s staticDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestStream(t *testing.T) {
+	f, _ := filepath.Abs("loader.go")
+	expect, _ := os.ReadFile(f)
+	loader := New(WithDownloader("static", staticDownloader(expect)))
+
+	for _, uri := range []string{"file:///" + f, "static://loader.go"} {
+		r, err := loader.Stream(context.Background(), uri)
+		assert.NoError(t, err)
+
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, expect, b)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	loader := New()
+	for _, uri := range []string{writeTestFile(t, "hello world"), ts.URL} {
+		var buffer bytes.Buffer
+		n, err := loader.CopyTo(context.Background(), uri, &buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(11), n)
+		assert.Equal(t, "hello world", buffer.String())
+	}
+}
+
+func TestWithHTTP(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	loader := New(WithHTTP(loaderhttp.NewWithClient(ts.Client())))
+	b, err := loader.Load(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestWithSchemeAlias(t *testing.T) {
+	loader := New(
+		WithDownloader("static", staticDownloader("hello")),
+		WithSchemeAlias("alias", "static"),
+		WithSchemeAlias("missing", "unknown"),
+	)
+
+	b, err := loader.Load(context.Background(), "alias://test")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = loader.Load(context.Background(), "missing://test")
+	assert.True(t, errors.Is(err, ErrUnsupportedScheme))
+}
+
+func TestExists(t *testing.T) {
+	loader := New(
+		WithDownloader("static", staticDownloader("hello")),
+		WithDownloader("mem", memory.New()),
+	)
+
+	for uri, expect := range map[string]bool{
+		writeTestFile(t, "hello"):  true,
+		"file:///missing/test.txt": false,
+		"static://test":            true,
+		"mem://bucket/missing.txt": false,
+	} {
+		ok, err := loader.Exists(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, expect, ok, uri)
+	}
+
+	_, err := loader.Exists(context.Background(), "unknown://test")
+	assert.True(t, errors.Is(err, ErrUnsupportedScheme))
+}
+
+func TestStat(t *testing.T) {
+	loader := New(WithDownloader("static", staticDownloader("hello")))
+	for _, uri := range []string{writeTestFile(t, "hello"), "static://test"} {
+		meta, err := loader.Stat(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, int64(5), meta.Size, uri)
+	}
+
+	_, err := loader.Stat(context.Background(), "file:///missing/test.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestLoadError(t *testing.T) {
+	loader := New(WithDownloader("mem", memory.New()))
+
+	{ // The sentinel of the downloader is still reachable
+		_, err := loader.Load(context.Background(), "mem://bucket/missing.txt")
+		assert.ErrorContains(t, err, "mem://bucket/missing.txt")
+		assert.ErrorIs(t, err, memory.ErrNoSuchKey)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+
+		var loadErr *LoadError
+		assert.True(t, errors.As(err, &loadErr))
+		assert.Equal(t, "mem://bucket/missing.txt", loadErr.URI)
+		assert.Equal(t, "mem", loadErr.Scheme)
+	}
+
+	{ // Unsupported scheme
+		_, err := loader.Load(context.Background(), "FTP://host/test.txt")
+		assert.ErrorContains(t, err, "FTP://host/test.txt")
+		assert.ErrorIs(t, err, ErrUnsupportedScheme)
+
+		var loadErr *LoadError
+		assert.True(t, errors.As(err, &loadErr))
+		assert.Equal(t, "ftp", loadErr.Scheme)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	loader := New(WithDownloader("static", staticDownloader("hello")))
+	uri := "file:///" + filepath.ToSlash(filepath.Join(t.TempDir(), "hello.txt"))
+	assert.NoError(t, loader.Upload(context.Background(), uri, []byte("hello")))
+
+	b, err := loader.Load(context.Background(), uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	err = loader.Upload(context.Background(), "static://test", []byte("hello"))
+	assert.ErrorIs(t, err, ErrUnsupportedUpload)
+}
+
+func TestMaxSize(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte(body[:50]))
+			w.(http.Flusher).Flush() // No content length
+		}
+
+		w.Write([]byte(body[len(body)-50:]))
+	}))
+	defer ts.Close()
+
+	loader := New(WithMaxSize(10), WithDownloader("static", staticDownloader(body)))
+	for _, uri := range []string{ts.URL, ts.URL + "/chunked", writeTestFile(t, body), "static://test"} {
+		b, err := loader.Load(context.Background(), uri)
+		assert.Nil(t, b, uri)
+		assert.True(t, errors.Is(err, ErrTooLarge), uri)
+	}
+
+	b, err := loader.Load(context.Background(), writeTestFile(t, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestWithTLSConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	loader := New(WithTLSConfig(&tls.Config{RootCAs: pool}))
+
+	b, err := loader.Load(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestDownloaderFor(t *testing.T) {
+	loader := New()
+
+	dl, scheme, err := loader.DownloaderFor("FILE:///config.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", scheme)
+	assert.IsType(t, new(file.Client), dl)
+
+	_, _, err = loader.DownloaderFor("unknown://config.json")
+	assert.True(t, errors.Is(err, ErrUnsupportedScheme))
+}
+
+func TestLoadAll(t *testing.T) {
+	uris := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		uris = append(uris, writeTestFile(t, fmt.Sprintf("hello %d", i)))
+	}
+
+	out, err := New(WithConcurrency(3)).LoadAll(context.Background(), uris)
+	assert.NoError(t, err)
+	assert.Len(t, out, 10)
+	for i, uri := range uris {
+		assert.Equal(t, fmt.Sprintf("hello %d", i), string(out[uri]))
+	}
+}
+
+func TestLoadAllErrors(t *testing.T) {
+	valid := writeTestFile(t, "hello")
+	out, err := New().LoadAll(context.Background(), []string{
+		valid, "file:///missing/test.txt", "unknown://test",
+	})
+
+	assert.Equal(t, map[string][]byte{valid: []byte("hello")}, out)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.True(t, errors.Is(err, ErrUnsupportedScheme))
+	assert.Contains(t, err.Error(), "file:///missing/test.txt")
+
+	// Every error is reported once, with the URI of the resource
+	_, err = New(WithConcurrency(1)).LoadAll(context.Background(), []string{"unknown://a", "unknown://b"})
+	assert.Equal(t, "unable to load unknown://a: scheme is not supported: unknown\n"+
+		"unable to load unknown://b: scheme is not supported: unknown", err.Error())
+}
+
+func TestFingerprint(t *testing.T) {
+	content := "hello"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content)) // No entity tag, must be hashed
+	}))
+	defer ts.Close()
+
+	loader := New()
+	for _, uri := range []string{writeTestFile(t, "hello"), ts.URL} {
+		fp1, err := loader.Fingerprint(context.Background(), uri)
+		assert.NoError(t, err)
+		fp2, err := loader.Fingerprint(context.Background(), uri)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, fp1)
+		assert.Equal(t, fp1, fp2)
+	}
+
+	{ // Content changes
+		fp1, _ := loader.Fingerprint(context.Background(), ts.URL)
+		content = "hello world"
+		fp2, _ := loader.Fingerprint(context.Background(), ts.URL)
+		assert.NotEqual(t, fp1, fp2)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// ErrNoSuchKey is returned when the requested resource does not exist
+var ErrNoSuchKey error = resource.NotFoundError("key does not exist")
+
+// Client represents an in-memory downloader, useful for stubbing resources in tests.
+type Client struct {
+	lock    sync.RWMutex
+	objects map[string]object
+}
+
+type object struct {
+	data    []byte
+	modTime time.Time
+}
+
+// New creates a new, empty in-memory client.
+func New() *Client {
+	return &Client{
+		objects: make(map[string]object),
+	}
+}
+
+// Put stores the resource at the specified URI and sets its modification time to now.
+func (c *Client) Put(uri string, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.objects[uri] = object{
+		data:    data,
+		modTime: time.Now(),
+	}
+}
+
+// Upload stores the resource at the specified URI, see Put.
+func (c *Client) Upload(ctx context.Context, uri string, data []byte) error {
+	c.Put(uri, data)
+	return nil
+}
+
+// SetModTime sets the modification time of the resource at the specified URI. It returns
+// false if the resource does not exist.
+func (c *Client) SetModTime(uri string, modTime time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	o, ok := c.objects[uri]
+	if ok {
+		o.modTime = modTime
+		c.objects[uri] = o
+	}
+	return ok
+}
+
+// Delete removes the resource at the specified URI.
+func (c *Client) Delete(uri string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objects, uri)
+}
+
+// DownloadIf downloads a resource only if the updatedSince time is older than the resource
+// timestamp itself.
+func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a resource only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the resource along with its contents.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	c.lock.RLock()
+	o, ok := c.objects[uri]
+	c.lock.RUnlock()
+
+	switch {
+	case !ok:
+		return nil, resource.Meta{}, ErrNoSuchKey
+	case !isModified(o.modTime, updatedSince):
+		return nil, resource.Meta{}, nil
+	}
+
+	// Copy the contents so the caller can't modify the stored ones
+	b := make([]byte, len(o.data))
+	copy(b, o.data)
+	return b, resource.Meta{
+		LastModified: o.modTime,
+		Size:         int64(len(b)),
+	}, nil
+}
+
+// List returns the URIs of all of the resources starting with the specified URI, in
+// lexicographical order.
+func (c *Client) List(ctx context.Context, uri string) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var keys []string
+	for k := range c.objects {
+		if strings.HasPrefix(k, uri) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func isModified(updatedAt, updatedSince time.Time) bool {
+	return updatedAt.After(updatedSince)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	const url = "mem://config.json"
+	client := New()
+
+	{ // Missing
+		b, err := client.DownloadIf(context.Background(), url, time.Unix(0, 0))
+		assert.Nil(t, b)
+		assert.Equal(t, ErrNoSuchKey, err)
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	}
+
+	client.Put(url, []byte("hello"))
+	modTime := time.Now().Add(-time.Hour)
+	assert.True(t, client.SetModTime(url, modTime))
+	assert.False(t, client.SetModTime("mem://missing", modTime))
+
+	{ // Modified
+		b, meta, err := client.DownloadMeta(context.Background(), url, modTime.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, modTime, meta.LastModified)
+		assert.Equal(t, int64(5), meta.Size)
+	}
+
+	{ // Not modified
+		b, err := client.DownloadIf(context.Background(), url, modTime.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Deleted
+		client.Delete(url)
+		_, err := client.DownloadIf(context.Background(), url, time.Unix(0, 0))
+		assert.Equal(t, ErrNoSuchKey, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	client := New()
+	client.Put("mem://data/b.json", []byte("b"))
+	client.Put("mem://data/a.json", []byte("a"))
+	client.Put("mem://other.json", []byte("c"))
+
+	keys, err := client.List(context.Background(), "mem://data/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mem://data/a.json", "mem://data/b.json"}, keys)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+)
+
+// KeyUpdate represents an update of a single key under a watched prefix
+type KeyUpdate struct {
+	Update
+	Key string // The URI of the key which was updated
+}
+
+// prefixWatcher watches all of the keys under a prefix
+type prefixWatcher struct {
+	loader   *Loader              // The parent loader to use
+	uri      string               // The uri of the prefix to watch
+	interval time.Duration        // Interval between subsequent check calls
+	updates  chan KeyUpdate       // The update channel
+	seen     map[string]time.Time // The time of the last update of every known key
+	last     map[string]Meta      // The metadata of the last version loaded of every known key
+}
+
+// newPrefixWatcher creates a new watcher for the prefix
+func newPrefixWatcher(loader *Loader, uri string, interval time.Duration) *prefixWatcher {
+	return &prefixWatcher{
+		loader:   loader,
+		uri:      uri,
+		interval: intervalOf(interval),
+		updates:  make(chan KeyUpdate, max(1, loader.buffer)),
+		seen:     make(map[string]time.Time),
+		last:     make(map[string]Meta),
+	}
+}
+
+// checkLoop checks the prefix on a fixed cadence until the context is cancelled
+func (w *prefixWatcher) checkLoop(ctx context.Context) {
+	defer close(w.updates)
+	for {
+		w.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.loader.clock.After(w.interval):
+		}
+	}
+}
+
+// check lists the keys under the prefix and loads the ones which were added or modified
+// since the last check.
+func (w *prefixWatcher) check(ctx context.Context) {
+	keys, err := w.list(ctx)
+	if err != nil {
+		w.send(ctx, KeyUpdate{Key: w.uri, Update: Update{Err: err}})
+		return
+	}
+
+	// Forget the keys which are no longer present
+	present := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		present[key] = struct{}{}
+	}
+	for key := range w.seen {
+		if _, ok := present[key]; !ok {
+			delete(w.seen, key)
+			delete(w.last, key)
+		}
+	}
+
+	for _, key := range keys {
+		updatedSince, ok := w.seen[key]
+		if !ok {
+			updatedSince = zeroTime
+		}
+
+		now := w.loader.clock.Now()
+		b, meta, err := w.load(ctx, key, updatedSince)
+		if b == nil && err == nil {
+			continue // No updates, skip
+		}
+
+		if err == nil {
+			w.seen[key], w.last[key] = now, meta
+		}
+
+		if !w.send(ctx, KeyUpdate{Key: key, Update: Update{Data: b, Size: len(b), Err: err, Meta: meta}}) {
+			return
+		}
+	}
+}
+
+// list lists the keys under the prefix, the timeout applies to this attempt only
+func (w *prefixWatcher) list(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.loader.timeout)
+	defer cancel()
+	return w.loader.List(ctx, w.uri)
+}
+
+// load loads a single key if it was modified, the timeout applies to this attempt only
+func (w *prefixWatcher) load(ctx context.Context, key string, updatedSince time.Time) ([]byte, Meta, error) {
+	ctx, cancel := context.WithTimeout(resource.LastSeen(ctx, w.last[key]), w.loader.timeout)
+	defer cancel()
+	return w.loader.LoadWithMeta(ctx, key, updatedSince)
+}
+
+// send pushes the update out, unless the context is cancelled first. Unlike a single resource,
+// the updates of different keys are never dropped.
+func (w *prefixWatcher) send(ctx context.Context, update KeyUpdate) bool {
+	select {
+	case w.updates <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// limiters represents the token buckets gating the downloads, by scheme. The limiter registered
+// for the empty scheme is global and gates the downloads of every scheme.
+type limiters map[string]*rate.Limiter
+
+// wait waits until both the global limiter and the limiter of the scheme of the URI allow a
+// download, or the context is cancelled.
+func (l limiters) wait(ctx context.Context, uri string) error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	for _, scheme := range [...]string{"", schemeOf(uri)} {
+		if limiter, ok := l[scheme]; ok {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
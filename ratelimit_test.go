@@ -0,0 +1,77 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit(t *testing.T) {
+	for _, scheme := range []string{"test", ""} {
+		dl := new(countingDownloader)
+		loader := New(
+			WithDownloader("test", dl),
+			WithRateLimit(scheme, 20, 1),
+		)
+
+		// Hammer the downloader for a window of 500ms
+		var wg sync.WaitGroup
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					loader.Load(ctx, "test://hello")
+				}
+			}()
+		}
+
+		// The burst plus 20 per second, with some slack for the scheduling
+		wg.Wait()
+		assert.LessOrEqual(t, atomic.LoadInt64(&dl.count), int64(1+10+1), scheme)
+		assert.Greater(t, atomic.LoadInt64(&dl.count), int64(0), scheme)
+	}
+}
+
+func TestRateLimitOtherScheme(t *testing.T) {
+	dl := new(countingDownloader)
+	loader := New(
+		WithDownloader("test", dl),
+		WithRateLimit("other", rate.Every(time.Hour), 1),
+	)
+
+	// The limit of another scheme does not apply
+	for i := 0; i < 5; i++ {
+		_, err := loader.Load(context.Background(), "test://hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(5), atomic.LoadInt64(&dl.count))
+}
+
+func TestRateLimitCanceled(t *testing.T) {
+	dl := new(countingDownloader)
+	loader := New(
+		WithDownloader("test", dl),
+		WithRateLimit("test", rate.Every(time.Hour), 1),
+	)
+
+	_, err := loader.Load(context.Background(), "test://hello")
+	assert.NoError(t, err)
+
+	// The next token is an hour away, so waiting for it fails
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = loader.Load(ctx, "test://hello")
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+}
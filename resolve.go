@@ -0,0 +1,39 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resolve returns the URI of the resource. A relative path without a scheme is joined to the
+// base prefix, if any. Otherwise, since some tools pass bare paths, a path without a scheme is
+// loaded from the file system, with a relative path resolved against the base directory, or
+// the working directory if none is set.
+func (l *Loader) resolve(uri string) (string, error) {
+	if u, err := url.Parse(uri); err == nil && u.Scheme != "" && !filepath.IsAbs(uri) {
+		return uri, nil
+	}
+
+	// Join the relative path to the base prefix, without escaping it
+	if l.baseURI != "" && !filepath.IsAbs(uri) {
+		rel := path.Clean("/" + filepath.ToSlash(uri))
+		return strings.TrimSuffix(l.baseURI, "/") + rel, nil
+	}
+
+	path := uri
+	if !filepath.IsAbs(path) && l.baseDir != "" {
+		path = filepath.Join(l.baseDir, path)
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return "file:///" + filepath.ToSlash(path), nil
+}
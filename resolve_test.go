@@ -0,0 +1,94 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelindar/loader/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvePaths(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "rel"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rel", "config.json"), []byte("hello"), 0644))
+
+	loader := New(WithBaseDir(dir))
+	for _, uri := range []string{
+		filepath.Join(dir, "rel", "config.json"),
+		"./rel/config.json",
+		"rel/config.json",
+		"file:///" + filepath.Join(dir, "rel", "config.json"),
+	} {
+		b, err := loader.Load(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, "hello", string(b), uri)
+
+		_, scheme, err := loader.DownloaderFor(uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, "file", scheme, uri)
+	}
+}
+
+func TestResolveWorkingDir(t *testing.T) {
+	b, err := New().Load(context.Background(), "./resolve.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "package loader")
+}
+
+func TestResolve(t *testing.T) {
+	loader := New(WithBaseDir("/etc/app"))
+	for uri, expect := range map[string]string{
+		"/etc/app/config.json":   "file:////etc/app/config.json",
+		"./config.json":          "file:////etc/app/config.json",
+		"../config.json":         "file:////etc/config.json",
+		"s3://bucket/config":     "s3://bucket/config",
+		"file:///etc/app/a.json": "file:///etc/app/a.json",
+	} {
+		resolved, err := loader.resolve(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, expect, resolved, uri)
+	}
+}
+
+func TestBasePrefix(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://bucket/env/prod/config.json", []byte("prod"))
+	mem.Put("mem://bucket/env/dev/config.json", []byte("dev"))
+
+	for _, prefix := range []string{"mem://bucket/env/prod/", "mem://bucket/env/prod"} {
+		loader := New(WithDownloader("mem", mem), WithBasePrefix(prefix))
+		for uri, expect := range map[string]string{
+			"config.json":                      "prod",
+			"./config.json":                    "prod",
+			"mem://bucket/env/dev/config.json": "dev",
+		} {
+			b, err := loader.Load(context.Background(), uri)
+			assert.NoError(t, err, uri)
+			assert.Equal(t, expect, string(b), uri)
+		}
+	}
+}
+
+func TestResolveBasePrefix(t *testing.T) {
+	loader := New(WithBasePrefix("s3://bucket/env/prod/"))
+	for uri, expect := range map[string]string{
+		"config.json":           "s3://bucket/env/prod/config.json",
+		"./a/b.json":            "s3://bucket/env/prod/a/b.json",
+		"a//b.json":             "s3://bucket/env/prod/a/b.json",
+		"../../secret.json":     "s3://bucket/env/prod/secret.json",
+		"config.json?v=1":       "s3://bucket/env/prod/config.json?v=1",
+		"gs://bucket/app.json":  "gs://bucket/app.json",
+		"https://example.com/a": "https://example.com/a",
+		"/etc/app/config.json":  "file:////etc/app/config.json",
+	} {
+		resolved, err := loader.resolve(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, expect, resolved, uri)
+	}
+}
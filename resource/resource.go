@@ -0,0 +1,128 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrTooLarge is returned when a resource exceeds the maximum size allowed for a download
+var ErrTooLarge = errors.New("resource is too large")
+
+// Meta represents the metadata of a resource, as reported by its backend.
+type Meta struct {
+	LastModified    time.Time // The last modification time of the resource
+	Size            int64     // The size of the resource, in bytes
+	ETag            string    // The entity tag of the resource, if available
+	ContentType     string    // The content type of the resource, if available
+	ContentEncoding string    // The content encoding of the resource (e.g. gzip), if available
+	Generation      int64     // The generation of the resource on versioned backends, if available
+	Marker          string    // The value of the custom metadata which marks the changes, if any
+	Checksum        string    // The hash of the contents, if computed by the backend
+	Target          string    // The path of the resource with its symbolic links resolved, if tracked
+}
+
+// NotFoundError represents an error returned when a resource does not exist. It matches
+// fs.ErrNotExist, so callers can check for it with errors.Is regardless of the backend.
+type NotFoundError string
+
+// Error returns the error message
+func (e NotFoundError) Error() string {
+	return string(e)
+}
+
+// Is reports whether the target error is fs.ErrNotExist
+func (e NotFoundError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// maxSizeKey is the context key of the maximum size of the resources
+type maxSizeKey struct{}
+
+// LimitSize returns a context which limits the size of the resources downloaded with it to
+// n bytes. The downloaders should check the size before transferring the contents, if known.
+func LimitSize(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxSizeKey{}, n)
+}
+
+// MaxSizeOf returns the maximum size of the resources downloaded with the context, or zero if
+// the size is not limited.
+func MaxSizeOf(ctx context.Context) int64 {
+	n, _ := ctx.Value(maxSizeKey{}).(int64)
+	return n
+}
+
+// exactKeysKey is the context key of the exact matching of the keys
+type exactKeysKey struct{}
+
+// ExactKeys returns a context with which the keys of the objects are always matched exactly,
+// rather than treated as a prefix to scan for the latest object.
+func ExactKeys(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exactKeysKey{}, true)
+}
+
+// IsExactKeys returns whether the keys of the objects must be matched exactly with the context
+func IsExactKeys(ctx context.Context) bool {
+	exact, _ := ctx.Value(exactKeysKey{}).(bool)
+	return exact
+}
+
+// PrefixOf returns the prefix to scan for the latest object if the key is empty or ends with a
+// trailing prefix marker, which is either a '/' or a '*'. Any other key is an exact key.
+func PrefixOf(key string) (string, bool) {
+	switch {
+	case key == "" || strings.HasSuffix(key, "/"):
+		return key, true
+	case strings.HasSuffix(key, "*"):
+		return strings.TrimSuffix(key, "*"), true
+	default:
+		return "", false
+	}
+}
+
+// URIOf returns the URI of a key listed under the prefix of the original URI, preserving its
+// form (e.g. 'gs://bucket/data/a.json' or 'https://storage.googleapis.com/bucket/data/a.json').
+func URIOf(uri, prefix, key string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	u.RawQuery, u.Fragment = "", ""
+	u.Path = strings.TrimSuffix(u.Path, prefix) + key
+	u.RawPath = ""
+	return u.String()
+}
+
+// lastSeenKey is the context key of the metadata of the version last seen by the caller
+type lastSeenKey struct{}
+
+// LastSeen returns a context which carries the metadata of the version of the resource last
+// loaded by the caller (e.g. a watcher), so the downloaders decide whether it was modified with
+// the validators of the caller itself, such as the entity tag, rather than with a state shared
+// by every caller of the client.
+func LastSeen(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, lastSeenKey{}, meta)
+}
+
+// LastSeenOf returns the metadata of the version last seen by the caller, or the zero value if
+// the caller did not load the resource before.
+func LastSeenOf(ctx context.Context) Meta {
+	meta, _ := ctx.Value(lastSeenKey{}).(Meta)
+	return meta
+}
+
+// CheckSize returns ErrTooLarge if the size exceeds the maximum size allowed by the context
+func CheckSize(ctx context.Context, size int64) error {
+	if limit := MaxSizeOf(ctx); limit > 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrTooLarge, size, limit)
+	}
+	return nil
+}
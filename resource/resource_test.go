@@ -0,0 +1,39 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixOf(t *testing.T) {
+	for _, tc := range []struct {
+		key, prefix string
+		ok          bool
+	}{
+		{"dir/key.txt", "", false},
+		{"dir/", "dir/", true},
+		{"dir/key-*", "dir/key-", true},
+		{"", "", true},
+	} {
+		prefix, ok := PrefixOf(tc.key)
+		assert.Equal(t, tc.ok, ok, tc.key)
+		assert.Equal(t, tc.prefix, prefix, tc.key)
+	}
+}
+
+func TestURIOf(t *testing.T) {
+	for _, tc := range []struct {
+		uri, prefix, key, expect string
+	}{
+		{"gs://bucket/data/", "data/", "data/a.json", "gs://bucket/data/a.json"},
+		{"s3://bucket/data*", "data*", "data-1.json", "s3://bucket/data-1.json"},
+		{"https://storage.googleapis.com/bucket/data/?x=1", "data/", "data/a.json", "https://storage.googleapis.com/bucket/data/a.json"},
+		{"az://container/", "", "dir/a b.json", "az://container/dir/a%20b.json"},
+	} {
+		assert.Equal(t, tc.expect, URIOf(tc.uri, tc.prefix, tc.key), tc.uri)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	stdhttp "net/http"
+	"syscall"
+	"time"
+
+	"github.com/kelindar/loader/http"
+)
+
+// downloadWithRetry downloads the resource, retrying on transient errors with a backoff
+// between the attempts until the maximum number of attempts is reached.
+func (l *Loader) downloadWithRetry(ctx context.Context, client Downloader, uri string, updatedSince time.Time) ([]byte, Meta, error) {
+	for attempt := 1; ; attempt++ {
+		if err := l.limits.wait(ctx, uri); err != nil {
+			return nil, Meta{}, err
+		}
+
+		b, meta, err := download(ctx, client, uri, updatedSince)
+		if err == nil || attempt >= l.attempts || !isTransient(err) {
+			return b, meta, err
+		}
+
+		// Wait before the next attempt, unless cancelled in the meantime
+		timer := time.NewTimer(max(l.backoff(attempt), retryAfterOf(err)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, Meta{}, err
+		case <-timer.C:
+		}
+	}
+}
+
+// transientCodes are the error codes of the services (e.g. S3) which report a throttling, a
+// timeout or a failure on their side, rather than an issue with the request itself.
+var transientCodes = map[string]bool{
+	"RequestError":             true,
+	"RequestTimeout":           true,
+	"RequestTimeoutException":  true,
+	"RequestLimitExceeded":     true,
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"TooManyRequestsException": true,
+	"InternalError":            true,
+	"ServiceUnavailable":       true,
+}
+
+// isTransient returns whether the error may go away when retried. Only the network errors, the
+// timeouts and the throttling or server errors are retried, since any other error (e.g. a denied
+// access, a missing resource or an invalid URI) would fail the same way again.
+func isTransient(err error) bool {
+	var (
+		statusErr *http.HTTPStatusError
+		codeErr   interface{ Code() string }    // e.g. the errors of the S3 client
+		serverErr interface{ StatusCode() int } // e.g. the errors of the S3 or GCS clients
+		dnsErr    *net.DNSError
+		opErr     *net.OpError
+		netErr    net.Error
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &statusErr):
+		return isTransientStatus(statusErr.Code)
+	case errors.As(err, &codeErr) && transientCodes[codeErr.Code()]:
+		return true
+	case errors.As(err, &serverErr):
+		return isTransientStatus(serverErr.StatusCode())
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	case errors.As(err, &opErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	default:
+		return false
+	}
+}
+
+// isTransientStatus returns whether the HTTP status code reports a timeout, a throttling or
+// a failure of the server
+func isTransientStatus(code int) bool {
+	return code == stdhttp.StatusRequestTimeout ||
+		code == stdhttp.StatusTooManyRequests ||
+		code >= stdhttp.StatusInternalServerError
+}
+
+// retryAfterOf returns the delay the server asked to wait before retrying, if any
+func retryAfterOf(err error) time.Duration {
+	var statusErr *http.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// exponentialBackoff doubles the delay after every attempt, starting at 100ms
+func exponentialBackoff(attempt int) time.Duration {
+	return 100 * time.Millisecond << (attempt - 1)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kelindar/loader/file"
+	"github.com/kelindar/loader/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	noDelay := func(int) time.Duration { return 0 }
+
+	{ // Fails twice, then succeeds on the third attempt
+		dl := &flakyDownloader{failures: 2}
+		loader := New(WithDownloader("flaky", dl), WithRetry(3, noDelay))
+		b, err := loader.Load(context.Background(), "flaky://test")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, int64(3), atomic.LoadInt64(&dl.calls))
+	}
+
+	{ // Not enough attempts
+		dl := &flakyDownloader{failures: 2}
+		loader := New(WithDownloader("flaky", dl), WithRetry(2, noDelay))
+		_, err := loader.Load(context.Background(), "flaky://test")
+		assert.Error(t, err)
+		assert.Equal(t, int64(2), atomic.LoadInt64(&dl.calls))
+	}
+
+	{ // No retries by default
+		dl := &flakyDownloader{failures: 2}
+		loader := New(WithDownloader("flaky", dl))
+		_, err := loader.Load(context.Background(), "flaky://test")
+		assert.Error(t, err)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dl.calls))
+	}
+
+	{ // Missing resources are not retried
+		dl := &flakyDownloader{failures: 2, err: fs.ErrNotExist}
+		loader := New(WithDownloader("flaky", dl), WithRetry(3, noDelay))
+		_, err := loader.Load(context.Background(), "flaky://test")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dl.calls))
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	_, parseErr := url.Parse("flaky://test\x7f")
+	for _, err := range []error{
+		&http.HTTPStatusError{Code: 400, Status: "400 Bad Request"},
+		&http.HTTPStatusError{Code: 401, Status: "401 Unauthorized"},
+		&http.HTTPStatusError{Code: 403, Status: "403 Forbidden"},
+		&http.HTTPStatusError{Code: 404, Status: "404 Not Found"},
+		&http.HTTPStatusError{Code: 410, Status: "410 Gone"},
+		fmt.Errorf("%w: localhost", http.ErrBlockedHost),
+		fmt.Errorf("%w: ../secret", file.ErrOutsideRoot),
+		&codeError{code: "AccessDenied", status: 403},
+		&net.DNSError{Err: "no such host", Name: "test", IsNotFound: true},
+		parseErr,
+		ErrTooLarge,
+		errors.New("unknown"),
+	} {
+		dl := &flakyDownloader{failures: 2, err: err}
+		loader := New(WithDownloader("flaky", dl), WithRetry(3, func(int) time.Duration { return 0 }))
+		_, loadErr := loader.Load(context.Background(), "flaky://test")
+		assert.ErrorIs(t, loadErr, err)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dl.calls), err.Error())
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	for _, err := range []error{
+		&http.HTTPStatusError{Code: 408, Status: "408 Request Timeout"},
+		&http.HTTPStatusError{Code: 429, Status: "429 Too Many Requests"},
+		&http.HTTPStatusError{Code: 500, Status: "500 Internal Server Error"},
+		&http.HTTPStatusError{Code: 503, Status: "503 Service Unavailable"},
+		&codeError{code: "SlowDown", status: 503},
+		&codeError{code: "RequestError"},
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		&url.Error{Op: "Get", URL: "http://test", Err: syscall.ECONNRESET},
+		io.ErrUnexpectedEOF,
+	} {
+		dl := &flakyDownloader{failures: 2, err: err}
+		loader := New(WithDownloader("flaky", dl), WithRetry(3, func(int) time.Duration { return 0 }))
+		b, loadErr := loader.Load(context.Background(), "flaky://test")
+		assert.NoError(t, loadErr)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, int64(3), atomic.LoadInt64(&dl.calls), err.Error())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	throttled := &http.HTTPStatusError{Code: 429, Status: "429 Too Many Requests", RetryAfter: 200 * time.Millisecond}
+	dl := &flakyDownloader{failures: 1, err: throttled}
+	loader := New(WithDownloader("flaky", dl), WithRetry(2, func(int) time.Duration { return 0 }))
+
+	// The retry waits for as long as the server asked
+	start := time.Now()
+	b, err := loader.Load(context.Background(), "flaky://test")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestWatchRetryAfter(t *testing.T) {
+	throttled := &http.HTTPStatusError{Code: 503, Status: "503 Service Unavailable", RetryAfter: 300 * time.Millisecond}
+	dl := &flakyDownloader{failures: 1, err: throttled}
+	loader := New(WithDownloader("flaky", dl))
+
+	// The check after the throttled one is delayed, despite the short interval
+	start := time.Now()
+	for u := range loader.Watch(context.Background(), "flaky://test", 20*time.Millisecond) {
+		if u.Err == nil {
+			assert.Equal(t, "hello", string(u.Data))
+			break
+		}
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&dl.calls))
+	loader.Unwatch("flaky://test")
+}
+
+func TestRetryCancel(t *testing.T) {
+	dl := &flakyDownloader{failures: 2}
+	loader := New(WithDownloader("flaky", dl), WithRetry(3, func(int) time.Duration {
+		return time.Hour
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := loader.Load(ctx, "flaky://test")
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dl.calls))
+}
+
+// codeError mimics the errors of the cloud storage clients, with a code and a status
+type codeError struct {
+	code   string
+	status int
+}
+
+func (e *codeError) Error() string   { return e.code }
+func (e *codeError) Code() string    { return e.code }
+func (e *codeError) StatusCode() int { return e.status }
+
+// flakyDownloader fails a number of times before succeeding
+type flakyDownloader struct {
+	failures int64
+	calls    int64
+	err      error
+}
+
+func (d *flakyDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	if atomic.AddInt64(&d.calls, 1) <= d.failures {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, &http.HTTPStatusError{Code: 503, Status: "503 Service Unavailable"}
+	}
+
+	return []byte("hello"), nil
+}
@@ -4,10 +4,17 @@
 package s3
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -15,27 +22,127 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/kelindar/loader/resource"
 )
 
+// resumeAttempts is the number of consecutive failed attempts before a resumable download fails
+const resumeAttempts = 5
+
 var (
 	// ErrNoSuchBucket is returned when the requested bucket does not exist
-	ErrNoSuchBucket = errors.New("bucket does not exist")
+	ErrNoSuchBucket error = resource.NotFoundError("bucket does not exist")
 
 	// ErrNoSuchKey is returned when the requested file does not exist
-	ErrNoSuchKey = errors.New("key does not exist")
+	ErrNoSuchKey error = resource.NotFoundError("key does not exist")
 )
 
 // Client represents the client implementation for the S3 downloader.
 type Client struct {
 	client     *s3.S3
 	downloader *s3manager.Downloader
+	uploader   *s3manager.Uploader
+	failover   []*Client // The clients to fall back to, in order
+	sseAlgo    *string   // The algorithm of the customer-provided encryption key, if any
+	sseKey     *string   // The customer-provided encryption key (SSE-C), if any
+	exact      bool      // Whether the keys are always matched exactly, never as a prefix
+	pathStyle  *bool     // Whether the path-style addressing is used, if set explicitly
+	trigger    string    // The custom metadata key which marks the changes, if any
+}
+
+// Options represents the set of options for creating an S3 client with a specific set of
+// credentials, such as a named profile or an assumed role.
+type Options struct {
+	Region     string // The region or a custom endpoint (for testing)
+	Retries    int    // The maximum number of retries
+	Profile    string // The named profile from the shared credentials file
+	RoleARN    string // The ARN of the role to assume, if any
+	ExternalID string // The external ID to use when assuming the role, if any
 }
 
 // New a new S3 Client.
 func New(region string, retries int) (*Client, error) {
+	sess, err := session.NewSession(newConfig(region, retries))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFromSession(sess), nil
+}
+
+// NewWithFailover creates a new S3 Client for the first region or endpoint, which falls back
+// to the other ones in order when a download fails, for example with cross-region replicated
+// buckets. Only the network and server errors fall back, while any other error (e.g. a missing
+// bucket or key) is considered definitive. The options apply to the clients of every region.
+func NewWithFailover(regions []string, retries int, options ...func(*Client)) (*Client, error) {
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+
+	clients := make([]*Client, 0, len(regions))
+	for _, region := range regions {
+		sess, err := session.NewSession(newConfig(region, retries))
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, NewFromSession(sess, options...))
+	}
+
+	clients[0].failover = clients[1:]
+	return clients[0], nil
+}
+
+// NewWithOptions creates a new S3 Client which uses the credentials of a named profile and/or
+// assumes a role. If neither is specified, the default credentials chain is used.
+func NewWithOptions(options Options) (*Client, error) {
+	sess, err := session.NewSession(newConfig(options.Region, options.Retries))
+	if err != nil {
+		return nil, err
+	}
+
+	if provider := providerOf(sess, options); provider != nil {
+		sess = sess.Copy(aws.NewConfig().WithCredentials(credentials.NewCredentials(provider)))
+	}
+
+	return NewFromSession(sess), nil
+}
+
+// providerOf returns the credentials provider for the options, or nil if the default one
+// should be used instead.
+func providerOf(sess *session.Session, options Options) credentials.Provider {
+	var provider credentials.Provider
+	if options.Profile != "" {
+		provider = &credentials.SharedCredentialsProvider{Profile: options.Profile}
+	}
+
+	// Assume the role, optionally using the profile as the source credentials
+	if options.RoleARN != "" {
+		if provider != nil {
+			sess = sess.Copy(aws.NewConfig().WithCredentials(credentials.NewCredentials(provider)))
+		}
+
+		role := &stscreds.AssumeRoleProvider{
+			Client:   sts.New(sess),
+			RoleARN:  options.RoleARN,
+			Duration: stscreds.DefaultDuration,
+		}
+		if options.ExternalID != "" {
+			role.ExternalID = aws.String(options.ExternalID)
+		}
+		provider = role
+	}
+
+	return provider
+}
+
+// newConfig creates a new AWS configuration for the region or a custom endpoint
+func newConfig(region string, retries int) *aws.Config {
 	conf := aws.NewConfig().WithMaxRetries(retries)
 
 	// Set the region or endpoint (for testing)
@@ -54,13 +161,7 @@ func New(region string, retries int) (*Client, error) {
 		conf = conf.WithRegion("us-east-1")
 	}
 
-	// Create the session
-	sess, err := session.NewSession(conf)
-	if err != nil {
-		return nil, err
-	}
-
-	return NewFromSession(sess), nil
+	return conf
 }
 
 // NewWithConfig creates new S3 Client with passed config
@@ -72,48 +173,272 @@ func NewWithConfig(config *aws.Config) (*Client, error) {
 	return NewFromSession(sess), nil
 }
 
+// NewCompatible creates a new S3 Client for an S3-compatible store, such as Cloudflare R2,
+// DigitalOcean Spaces or Backblaze B2, using the endpoint and static credentials as given. If
+// the region is empty, "us-east-1" is used for signing the requests.
+func NewCompatible(endpoint, region, accessKey, secretKey string, pathStyle bool) (*Client, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return NewWithConfig(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(endpoint).
+		WithS3ForcePathStyle(pathStyle).
+		WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, "")))
+}
+
 // NewFromSession a new S3 Client with the supplied AWS session
-func NewFromSession(sess *session.Session) *Client {
-	concurrency := runtime.NumCPU() * 4
-	return &Client{
-		downloader: s3manager.NewDownloader(sess, func(d *s3manager.Downloader) { d.Concurrency = concurrency }),
-		client:     s3.New(sess),
+func NewFromSession(sess *session.Session, options ...func(*Client)) *Client {
+	return NewWithTuning(sess, 0, 0, options...)
+}
+
+// NewWithTuning creates a new S3 Client with the supplied AWS session and the number of parts
+// downloaded concurrently along with the size of each part. Zero values keep the defaults, which
+// are 4 parts per CPU and the part size of the SDK.
+func NewWithTuning(sess *session.Session, concurrency int, partSize int64, options ...func(*Client)) *Client {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU() * 4
+	}
+
+	c := new(Client)
+	for _, option := range options {
+		option(c)
+	}
+
+	// Override the addressing of the session, if set explicitly
+	config := aws.NewConfig()
+	if c.pathStyle != nil {
+		config = config.WithS3ForcePathStyle(*c.pathStyle)
+	}
+
+	c.client = s3.New(sess, config)
+	c.uploader = s3manager.NewUploaderWithClient(c.client)
+	c.downloader = s3manager.NewDownloaderWithClient(c.client, func(d *s3manager.Downloader) {
+		d.Concurrency = concurrency
+		if partSize > 0 {
+			d.PartSize = partSize
+		}
+	})
+	return c
+}
+
+// WithPathStyle sets whether the bucket is addressed in the path of the URL (path-style) rather
+// than in the host name (virtual-host), regardless of the endpoint. Path-style addressing is
+// often required by S3-compatible stores, such as MinIO or Ceph. By default, it is only used
+// with a custom endpoint.
+func WithPathStyle(enabled bool) func(*Client) {
+	return func(c *Client) {
+		c.pathStyle = aws.Bool(enabled)
+	}
+}
+
+// WithCustomerKey sets the customer-provided key (e.g. a 256-bit key with the 'AES256'
+// algorithm) of the objects encrypted with SSE-C, which is sent along with every request for
+// an object. The key must be sent over HTTPS.
+func WithCustomerKey(algorithm, key string) func(*Client) {
+	return func(c *Client) {
+		c.sseAlgo = aws.String(algorithm)
+		c.sseKey = aws.String(key)
+	}
+}
+
+// WithExactKeys makes the client always download the object with the exact key of the URI,
+// even if it ends with a '/' or a '*', instead of the latest object under that prefix.
+func WithExactKeys() func(*Client) {
+	return func(c *Client) {
+		c.exact = true
+	}
+}
+
+// WithMetadataTrigger sets the custom metadata key (e.g. 'x-amz-meta-version' or 'version')
+// whose value marks the changes of the objects, for producers which rewrite the objects without
+// meaningful changes. An object carrying the key is only downloaded again once the value differs
+// from the one last seen by the caller (e.g. a watcher), carried by the context with
+// resource.LastSeen, regardless of its modification time. The objects without the key, or the
+// callers which have not seen a value yet, fall back to the modification time.
+func WithMetadataTrigger(key string) func(*Client) {
+	return func(c *Client) {
+		c.trigger = strings.TrimPrefix(strings.ToLower(key), "x-amz-meta-")
+	}
+}
+
+// isModifiedSince returns whether the object was modified since the time, according to the
+// metadata trigger if the object carries it and the caller has seen a value before.
+func (s *Client) isModifiedSince(ctx context.Context, head *s3.HeadObjectOutput, updatedSince time.Time) bool {
+	last := resource.LastSeenOf(ctx)
+	if marker, ok := s.markerOf(head); ok && last.Marker != "" && updatedSince.Unix() > 0 {
+		return last.Marker != marker
+	}
+
+	return isModified(aws.TimeValue(head.LastModified), updatedSince)
+}
+
+// markerOf returns the value of the metadata trigger of the object, if any
+func (s *Client) markerOf(head *s3.HeadObjectOutput) (string, bool) {
+	if s.trigger == "" {
+		return "", false
+	}
+
+	for k, v := range head.Metadata {
+		if strings.EqualFold(k, s.trigger) {
+			return aws.StringValue(v), true
+		}
+	}
+	return "", false
+}
+
+// headInput returns the input of a HEAD request for the object
+func (s *Client) headInput(bucket, key string) *s3.HeadObjectInput {
+	return &s3.HeadObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		SSECustomerAlgorithm: s.sseAlgo,
+		SSECustomerKey:       s.sseKey,
+	}
+}
+
+// getInput returns the input of a GET request for the object
+func (s *Client) getInput(bucket, key string) *s3.GetObjectInput {
+	return &s3.GetObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		SSECustomerAlgorithm: s.sseAlgo,
+		SSECustomerKey:       s.sseKey,
 	}
 }
 
 // DownloadIf downloads a file only if the updatedSince time is older than the resource
 // timestamp itself.
 func (s *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
-	bucket, key, err := parseURI(uri)
+	b, _, err := s.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the object along with its contents.
+func (s *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	b, meta, err := s.downloadMeta(ctx, uri, updatedSince)
+	for i := 0; i < len(s.failover) && canFailover(ctx, err); i++ {
+		b, meta, err = s.failover[i].downloadMeta(ctx, uri, updatedSince)
+	}
+	return b, meta, err
+}
+
+// downloadMeta downloads a file from this region only, see DownloadMeta.
+func (s *Client) downloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
-		return nil, err
+		return nil, resource.Meta{}, err
 	}
 
 	// Use the head operation to retrieve the last modified date
-	head, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	head, err := s.client.HeadObjectWithContext(ctx, s.headInput(bucket, key))
 	switch {
 	case err != nil:
-		return nil, convertError(err)
+		return nil, resource.Meta{}, convertError(err)
 	case head.LastModified == nil:
-		return nil, nil
-	case !isModified(*head.LastModified, updatedSince):
-		return nil, nil
+		return nil, resource.Meta{}, nil
+	case !s.isModifiedSince(ctx, head, updatedSince):
+		return nil, resource.Meta{}, nil
+	}
+
+	// Make sure the object is not too large to be downloaded
+	if err := resource.CheckSize(ctx, aws.Int64Value(head.ContentLength)); err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// Download the object
+	b, err := s.download(ctx, bucket, key)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	meta := metaOf(head)
+	meta.Size = int64(len(b))
+	meta.Marker, _ = s.markerOf(head)
+	return b, meta, nil
+}
+
+// Upload uploads the data as the object with the exact key of the URI, in several parts if it
+// is large.
+func (s *Client) Upload(ctx context.Context, uri string, data []byte) error {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(data),
+		SSECustomerAlgorithm: s.sseAlgo,
+		SSECustomerKey:       s.sseKey,
+	})
+	return convertError(err)
+}
+
+// Stat returns the metadata of the object without downloading it. If the URI ends with a '/'
+// or a '*', the metadata of the latest object under that prefix is returned.
+func (s *Client) Stat(ctx context.Context, uri string) (resource.Meta, error) {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return resource.Meta{}, err
 	}
 
-	// Download and return the updatedAt time
-	return s.Download(ctx, bucket, key)
+	head, err := s.client.HeadObjectWithContext(ctx, s.headInput(bucket, key))
+	if err != nil {
+		return resource.Meta{}, convertError(err)
+	}
+
+	return metaOf(head), nil
+}
+
+// metaOf returns the metadata of the object from the response of a HEAD request
+func metaOf(head *s3.HeadObjectOutput) resource.Meta {
+	return resource.Meta{
+		LastModified:    aws.TimeValue(head.LastModified),
+		Size:            aws.Int64Value(head.ContentLength),
+		ETag:            aws.StringValue(head.ETag),
+		ContentType:     aws.StringValue(head.ContentType),
+		ContentEncoding: aws.StringValue(head.ContentEncoding),
+	}
+}
+
+// DownloadIfNewer downloads the most recently modified object under the prefix, but only if
+// it was modified after the 'since' time. It returns the modification time of that object
+// regardless, and a nil payload if it was not modified.
+func (s *Client) DownloadIfNewer(ctx context.Context, bucket, prefix string, since time.Time) ([]byte, time.Time, error) {
+	latest, err := s.getLatestKey(ctx, bucket, prefix)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	updatedAt := aws.TimeValue(latest.LastModified)
+	if !isModified(updatedAt, since) {
+		return nil, updatedAt, nil
+	}
+
+	b, err := s.Download(ctx, bucket, aws.StringValue(latest.Key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return b, updatedAt, nil
 }
 
 // Download loads a specified object from the bucket
 func (s *Client) Download(ctx context.Context, bucket, key string) ([]byte, error) {
+	b, err := s.download(ctx, bucket, key)
+	for i := 0; i < len(s.failover) && canFailover(ctx, err); i++ {
+		b, err = s.failover[i].download(ctx, bucket, key)
+	}
+	return b, err
+}
+
+// download loads a specified object from the bucket in this region only
+func (s *Client) download(ctx context.Context, bucket, key string) ([]byte, error) {
 	w := new(aws.WriteAtBuffer)
-	n, err := s.downloader.DownloadWithContext(ctx, w, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	n, err := s.downloader.DownloadWithContext(ctx, w, s.getInput(bucket, key))
 	if err != nil {
 		return nil, convertError(err)
 	}
@@ -122,13 +447,281 @@ func (s *Client) Download(ctx context.Context, bucket, key string) ([]byte, erro
 	return w.Bytes()[:n], nil
 }
 
+// Fingerprint returns the entity tag of an object using the head operation.
+func (s *Client) Fingerprint(ctx context.Context, uri string) (string, error) {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := s.client.HeadObjectWithContext(ctx, s.headInput(bucket, key))
+	if err != nil {
+		return "", convertError(err)
+	}
+
+	return aws.StringValue(head.ETag), nil
+}
+
+// Exists checks whether the object exists using the head operation.
+func (s *Client) Exists(ctx context.Context, uri string) (bool, error) {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err == nil {
+		_, err = s.client.HeadObjectWithContext(ctx, s.headInput(bucket, key))
+	}
+
+	switch err := convertError(err); {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// Stream downloads an object and returns the response body without buffering it.
+func (s *Client) Stream(ctx context.Context, uri string) (io.ReadCloser, error) {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObjectWithContext(ctx, s.getInput(bucket, key))
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return out.Body, nil
+}
+
+// DownloadTo downloads the object into the writer and returns the number of bytes written.
+// If the writer is also an io.WriterAt (e.g. a file), the object is downloaded in concurrent
+// parts, otherwise it is streamed sequentially.
+func (s *Client) DownloadTo(ctx context.Context, uri string, w io.Writer) (int64, error) {
+	if dst, ok := w.(io.WriterAt); ok {
+		bucket, key, err := s.resolve(ctx, uri)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := s.downloader.DownloadWithContext(ctx, dst, s.getInput(bucket, key))
+		if err != nil {
+			return n, convertError(err)
+		}
+		return n, nil
+	}
+
+	r, err := s.Stream(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// DownloadHead downloads the first n bytes of an object using a ranged request.
+func (s *Client) DownloadHead(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, fmt.Sprintf("bytes=0-%d", n-1))
+}
+
+// DownloadTail downloads the last n bytes of an object using a ranged request.
+func (s *Client) DownloadTail(ctx context.Context, uri string, n int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, fmt.Sprintf("bytes=-%d", n))
+}
+
+// DownloadRange downloads length bytes of an object starting at the offset using a ranged
+// request.
+func (s *Client) DownloadRange(ctx context.Context, uri string, offset, length int64) ([]byte, error) {
+	return s.downloadRange(ctx, uri, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+}
+
+// downloadRange downloads a specific byte range of an object
+func (s *Client) downloadRange(ctx context.Context, uri, byteRange string) ([]byte, error) {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	input := s.getInput(bucket, key)
+	input.Range = aws.String(byteRange)
+	out, err := s.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// DownloadResumable downloads the object into the writer in parts, and resumes from the last
+// byte written when a transfer fails instead of starting over, for large objects downloaded
+// over an unreliable network. Every part must match the entity tag of the object, so the
+// download restarts from the beginning if the object changes in the meantime. The download
+// fails after 5 consecutive attempts without any progress, with an exponential backoff between
+// the attempts, or as soon as the context is done.
+func (s *Client) DownloadResumable(ctx context.Context, uri string, w io.WriterAt) error {
+	bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	var head *s3.HeadObjectOutput
+	var offset int64
+	for failures := 0; ; {
+		if head == nil {
+			if head, err = s.client.HeadObjectWithContext(ctx, s.headInput(bucket, key)); err != nil {
+				return convertError(err)
+			}
+
+			offset = 0
+			if err := resource.CheckSize(ctx, aws.Int64Value(head.ContentLength)); err != nil {
+				return err
+			}
+		}
+
+		// Download the next part, starting from the last byte written
+		size := aws.Int64Value(head.ContentLength)
+		if offset >= size {
+			return nil
+		}
+
+		last := min(offset+s.downloader.PartSize, size) - 1
+		n, err := s.downloadPart(ctx, bucket, key, head.ETag, offset, last, w)
+		offset += n
+		switch {
+		case err == nil:
+			failures = 0
+			continue
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case isPreconditionFailed(err):
+			head = nil // The object has changed, start over
+		case n > 0:
+			failures = 0
+		}
+
+		if failures++; failures >= resumeAttempts {
+			return err
+		}
+
+		// Wait before the next attempt, unless cancelled in the meantime
+		timer := time.NewTimer(resumeBackoff(failures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// resumeBackoff doubles the delay after every consecutive failed attempt, starting at 100ms
+func resumeBackoff(failures int) time.Duration {
+	return 100 * time.Millisecond << (failures - 1)
+}
+
+// downloadPart downloads the bytes of the object in the inclusive range into the writer, as long
+// as the object still has the entity tag, and returns the number of bytes written.
+func (s *Client) downloadPart(ctx context.Context, bucket, key string, etag *string, first, last int64, w io.WriterAt) (int64, error) {
+	input := s.getInput(bucket, key)
+	input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", first, last))
+	input.IfMatch = etag
+	out, err := s.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	defer out.Body.Close()
+	return io.Copy(io.NewOffsetWriter(w, first), out.Body)
+}
+
+// isPreconditionFailed returns whether the request failed since the entity tag did not match
+func isPreconditionFailed(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusPreconditionFailed
+}
+
+// List returns the URIs of all of the objects under the prefix, in lexicographical order. A
+// trailing prefix marker ("*") is ignored.
+func (s *Client) List(ctx context.Context, uri string) ([]string, error) {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(key, "*")
+	var keys []string
+	if err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, resource.URIOf(uri, key, aws.StringValue(o.Key)))
+		}
+		return true
+	}); err != nil {
+		return nil, convertError(err)
+	}
+
+	return keys, nil
+}
+
+// resolve parses the URI and returns its bucket and key. If the key ends with a trailing
+// prefix marker ("/" or "*"), the latest object under that prefix is selected instead, unless
+// the keys are matched exactly.
+func (s *Client) resolve(ctx context.Context, uri string) (string, string, error) {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix, ok := resource.PrefixOf(key)
+	if !ok || s.exact || resource.IsExactKeys(ctx) {
+		return bucket, key, nil
+	}
+
+	latest, err := s.getLatestKey(ctx, bucket, prefix)
+	if err != nil {
+		return "", "", err
+	}
+	return bucket, aws.StringValue(latest.Key), nil
+}
+
+// getLatestKey returns the latest uploaded object under the prefix in given bucket
+func (s *Client) getLatestKey(ctx context.Context, bucket, prefix string) (*s3.Object, error) {
+	var latest *s3.Object
+	if err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range page.Contents {
+			if o.LastModified == nil || aws.Int64Value(o.Size) == 0 {
+				continue
+			}
+
+			if latest == nil || isModified(*o.LastModified, *latest.LastModified) {
+				latest = o
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, convertError(err)
+	}
+
+	if latest == nil {
+		return nil, ErrNoSuchKey
+	}
+	return latest, nil
+}
+
 // convertError converts the error
 func convertError(err error) error {
 	if awsErr, ok := err.(awserr.Error); ok {
 		switch awsErr.Code() {
 		case s3.ErrCodeNoSuchBucket:
 			return ErrNoSuchBucket
-		case s3.ErrCodeNoSuchKey:
+		case s3.ErrCodeNoSuchKey, "NotFound":
 			return ErrNoSuchKey
 		}
 	}
@@ -136,16 +729,51 @@ func convertError(err error) error {
 	return err
 }
 
+// canFailover returns whether the error warrants trying another region, which is only the case
+// for the network and server errors. Any other error is definitive, as well as cancellations.
+func canFailover(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var reqErr awserr.RequestFailure
+	var awsErr awserr.Error
+	switch {
+	case errors.As(err, &reqErr):
+		return reqErr.StatusCode() >= http.StatusInternalServerError
+	case errors.As(err, &awsErr):
+		return awsErr.Code() == request.ErrCodeRequestError ||
+			awsErr.Code() == request.ErrCodeResponseTimeout
+	default:
+		return false
+	}
+}
+
 func isModified(updatedAt, updatedSince time.Time) bool {
-	return updatedAt.UTC().Unix() > updatedSince.UTC().Unix()
+	return updatedAt.After(updatedSince)
 }
 
-// parseURI returns bucket and prefix
+// endpoint matches the S3 endpoint hosts, with an optional virtual-hosted bucket
+var endpoint = regexp.MustCompile(`^(?:(.+)\.)?s3(?:[.-][a-z0-9-]+)*\.amazonaws\.com(?:\.cn)?$`)
+
+// parseURI returns bucket and key. The entire host is the bucket (e.g. 's3://my.bucket/key'),
+// unless it is an S3 endpoint, in which case the bucket is either the virtual-hosted prefix
+// of the host or, for path-style endpoints, the first segment of the path.
 func parseURI(uri string) (string, string, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return "", "", err
 	}
 
-	return strings.Split(u.Host, ".")[0], strings.TrimLeft(u.Path, "/"), nil
+	path := strings.TrimLeft(u.Path, "/")
+	match := endpoint.FindStringSubmatch(u.Host)
+	switch {
+	case match == nil:
+		return u.Host, path, nil
+	case match[1] != "":
+		return match[1], path, nil
+	default:
+		bucket, key, _ := strings.Cut(path, "/")
+		return bucket, key, nil
+	}
 }
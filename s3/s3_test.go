@@ -4,16 +4,33 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/kelindar/loader"
+	"github.com/kelindar/loader/resource"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,6 +56,660 @@ func TestS3(t *testing.T) {
 	val, err := cli.DownloadIf(context.Background(), "s3://bucket/hello.txt", time.Unix(0, 0))
 	assert.NoError(t, err)
 	assert.Equal(t, inputVal, val)
+
+	// Test DownloadMeta
+	val, meta, err := cli.DownloadMeta(context.Background(), "s3://bucket/hello.txt", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, inputVal, val)
+	assert.Equal(t, int64(len(inputVal)), meta.Size)
+	assert.Equal(t, fmt.Sprintf(`"%x"`, md5.Sum(inputVal)), meta.ETag)
+	assert.Equal(t, "text/plain", meta.ContentType)
+	assert.False(t, meta.LastModified.IsZero())
+
+	// Test DownloadHead and DownloadTail
+	head, err := cli.DownloadHead(context.Background(), "s3://bucket/hello.txt", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(head))
+	tail, err := cli.DownloadTail(context.Background(), "s3://bucket/hello.txt", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(tail))
+	mid, err := cli.DownloadRange(context.Background(), "s3://bucket/hello.txt", 3, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "lo wo", string(mid))
+
+	// Test Stream
+	r, err := cli.Stream(context.Background(), "s3://bucket/hello.txt")
+	assert.NoError(t, err)
+	val, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, inputVal, val)
+
+	// Test Fingerprint
+	fp1, err := cli.Fingerprint(context.Background(), "s3://bucket/hello.txt")
+	assert.NoError(t, err)
+	fp2, err := cli.Fingerprint(context.Background(), "s3://bucket/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, fp1, fp2)
+	s3.PutObject("hello.txt", []byte("hello there"))
+	fp3, err := cli.Fingerprint(context.Background(), "s3://bucket/hello.txt")
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp1, fp3)
+
+	// Test DownloadTo, sequentially and concurrently
+	var buffer bytes.Buffer
+	n, err := cli.DownloadTo(context.Background(), "s3://bucket/hello.txt", &buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.Equal(t, "hello there", buffer.String())
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "hello.txt"))
+	assert.NoError(t, err)
+	n, err = cli.DownloadTo(context.Background(), "s3://bucket/hello.txt", f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.NoError(t, f.Close())
+	val, err = os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello there", string(val))
+
+	// Test Exists
+	for uri, expect := range map[string]bool{
+		"s3://bucket/hello.txt":   true,
+		"s3://bucket/missing.txt": false,
+	} {
+		ok, err := cli.Exists(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, expect, ok, uri)
+	}
+}
+
+func TestDownloadIfNewer(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 5)
+	assert.NoError(t, err)
+
+	// Empty bucket
+	_, _, err = cli.DownloadIfNewer(context.Background(), "bucket", "", time.Unix(0, 0))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	// Add a few objects, one second apart
+	s3.PutObject("data/a.txt", []byte("a"))
+	s3.PutObject("data/b.txt", []byte("b"))
+	s3.PutObject("other.txt", []byte("c"))
+	s3.Touch("data/a.txt", -2*time.Second)
+	s3.Touch("data/b.txt", -time.Second)
+	updatedAt := time.Unix(0, s3.Objects["data/b.txt"].ModifiedAt)
+
+	{ // Newer
+		b, at, err := cli.DownloadIfNewer(context.Background(), "bucket", "data/", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "b", string(b))
+		assert.Equal(t, updatedAt.Unix(), at.Unix())
+	}
+
+	{ // Not newer
+		b, at, err := cli.DownloadIfNewer(context.Background(), "bucket", "data/", updatedAt.Add(time.Second))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+		assert.Equal(t, updatedAt.Unix(), at.Unix())
+	}
+
+	{ // Empty prefix
+		b, _, err := cli.DownloadIfNewer(context.Background(), "bucket", "", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "c", string(b))
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 5)
+	assert.NoError(t, err)
+
+	s3.PutObject("data/a.txt", []byte("a"))
+	s3.PutObject("data/b.txt", []byte("b"))
+	s3.PutObject("data", []byte("exact"))
+	s3.Touch("data/a.txt", -time.Second)
+	s3.Touch("data/b.txt", -2*time.Second)
+
+	for _, tc := range []struct {
+		uri, expect string
+	}{
+		{"s3://bucket/data", "exact"},
+		{"s3://bucket/data/", "a"},
+		{"s3://bucket/data/b*", "b"},
+		{"s3://bucket/data/b.txt", "b"},
+	} {
+		b, err := cli.DownloadIf(context.Background(), tc.uri, time.Unix(0, 0))
+		assert.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.expect, string(b), tc.uri)
+	}
+
+	{ // Nothing under the prefix
+		_, err := cli.DownloadIf(context.Background(), "s3://bucket/missing/", time.Unix(0, 0))
+		assert.Equal(t, ErrNoSuchKey, err)
+	}
+
+	{ // List the keys under the prefix
+		keys, err := cli.List(context.Background(), "s3://bucket/data/")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"s3://bucket/data/a.txt", "s3://bucket/data/b.txt"}, keys)
+
+		keys, err = cli.List(context.Background(), "https://bucket.s3.amazonaws.com/data/b*")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://bucket.s3.amazonaws.com/data/b.txt"}, keys)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	var failures int64
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failures, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	s3.PutObject("hello.txt", []byte("hello world"))
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := NewWithFailover([]string{broken.URL, ts.URL}, 0)
+	assert.NoError(t, err)
+
+	{ // Falls back to the second region
+		b, err := cli.DownloadIf(context.Background(), "s3://bucket/hello.txt", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+
+		b, err = cli.Download(context.Background(), "bucket", "hello.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+		assert.Equal(t, int64(2), atomic.LoadInt64(&failures))
+	}
+
+	{ // Missing keys do not fall back
+		cli, err := NewWithFailover([]string{ts.URL, broken.URL}, 0)
+		assert.NoError(t, err)
+
+		_, err = cli.DownloadIf(context.Background(), "s3://bucket/missing.txt", time.Unix(0, 0))
+		assert.Equal(t, ErrNoSuchKey, err)
+		assert.Equal(t, int64(2), atomic.LoadInt64(&failures))
+	}
+
+	{ // All regions fail
+		cli, err := NewWithFailover([]string{broken.URL, broken.URL}, 0)
+		assert.NoError(t, err)
+
+		_, err = cli.DownloadIf(context.Background(), "s3://bucket/hello.txt", time.Unix(0, 0))
+		assert.Error(t, err)
+		assert.Equal(t, int64(4), atomic.LoadInt64(&failures))
+	}
+
+	{ // Permanent errors do not fall back
+		cli, err := NewWithFailover([]string{ts.URL, broken.URL}, 0)
+		assert.NoError(t, err)
+
+		ctx := resource.LimitSize(context.Background(), 5)
+		_, err = cli.DownloadIf(ctx, "s3://bucket/hello.txt", time.Unix(0, 0))
+		assert.ErrorIs(t, err, resource.ErrTooLarge)
+		assert.Equal(t, int64(4), atomic.LoadInt64(&failures))
+	}
+}
+
+func TestFailoverOptions(t *testing.T) {
+	var denied int64
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&denied, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+
+	cli, err := NewWithFailover([]string{forbidden.URL, forbidden.URL}, 0,
+		WithExactKeys(), WithMetadataTrigger("X-Amz-Meta-Version"))
+	assert.NoError(t, err)
+
+	// The options apply to every region
+	for _, c := range append([]*Client{cli}, cli.failover...) {
+		assert.True(t, c.exact)
+		assert.Equal(t, "version", c.trigger)
+	}
+
+	// A denied access is definitive, so the second region is never tried
+	_, err = cli.DownloadIf(context.Background(), "s3://bucket/hello.txt", time.Unix(0, 0))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&denied))
+}
+
+func TestNewWithOptions(t *testing.T) {
+	cli, err := NewWithOptions(Options{
+		Region:  "eu-west-1",
+		Profile: "test",
+		RoleARN: "arn:aws:iam::123456789012:role/reader",
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, cli)
+}
+
+func TestStat(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	s3.PutObject("hello.txt", []byte("hello world"))
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+
+	for _, uri := range []string{"s3://bucket/hello.txt", "s3://bucket/hel*"} {
+		meta, err := cli.Stat(context.Background(), uri)
+		assert.NoError(t, err, uri)
+		assert.Equal(t, int64(11), meta.Size, uri)
+		assert.Equal(t, "text/plain", meta.ContentType, uri)
+		assert.False(t, meta.LastModified.IsZero(), uri)
+	}
+
+	_, err = cli.Stat(context.Background(), "s3://bucket/missing.txt")
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+}
+
+func TestUpload(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cli.Upload(context.Background(), "s3://bucket/a/hello.txt", []byte("hello world")))
+	b, err := cli.DownloadIf(context.Background(), "s3://bucket/a/hello.txt", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestExactKeys(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	// The named object is a prefix of a newer object
+	s3.PutObject("data*", []byte("named"))
+	s3.PutObject("data-2.json", []byte("newest"))
+	named := s3.Objects["data*"]
+	named.ModifiedAt = time.Now().Add(-time.Hour).UnixNano()
+	s3.Objects["data*"] = named
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+	exact, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+	WithExactKeys()(exact)
+
+	for _, tc := range []struct {
+		load   func() ([]byte, error)
+		expect string
+	}{
+		{expect: "newest", load: func() ([]byte, error) {
+			return cli.DownloadIf(context.Background(), "s3://bucket/data*", time.Unix(0, 0))
+		}},
+		{expect: "named", load: func() ([]byte, error) {
+			return exact.DownloadIf(context.Background(), "s3://bucket/data*", time.Unix(0, 0))
+		}},
+		{expect: "newest", load: func() ([]byte, error) {
+			return loader.New(loader.WithS3(cli)).Load(context.Background(), "s3://bucket/data*")
+		}},
+		{expect: "named", load: func() ([]byte, error) {
+			return loader.New(loader.WithS3(cli), loader.WithExactKeys()).Load(context.Background(), "s3://bucket/data*")
+		}},
+	} {
+		b, err := tc.load()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, string(b))
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+
+	_, err = loader.New(loader.WithS3(cli)).Load(context.Background(), "s3://bucket/missing.txt")
+	assert.ErrorContains(t, err, "s3://bucket/missing.txt")
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+}
+
+func TestMetadataTrigger(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+	WithMetadataTrigger("X-Amz-Meta-Version")(cli)
+
+	// putObject writes the object along with its version, if any
+	putObject := func(key, value, version string) {
+		s3.PutObject(key, []byte(value))
+		if version != "" {
+			o := s3.Objects[key]
+			o.Metadata = map[string]string{"Version": version}
+			s3.Objects[key] = o
+		}
+	}
+
+	putObject("config.json", "v1", "1")
+	putObject("plain.json", "v1", "")
+	for _, uri := range []string{"s3://bucket/config.json", "s3://bucket/plain.json"} {
+		b, err := cli.DownloadIf(context.Background(), uri, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", string(b))
+	}
+
+	// The version last seen by the caller
+	_, last, err := cli.DownloadMeta(context.Background(), "s3://bucket/config.json", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", last.Marker)
+	ctx := resource.LastSeen(context.Background(), last)
+
+	{ // Rewritten with the same version, not modified despite the newer modification time
+		putObject("config.json", "v1 rewritten", "1")
+		b, err := cli.DownloadIf(ctx, "s3://bucket/config.json", time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // A new version is modified, even once another caller has downloaded it
+		putObject("config.json", "v2", "2")
+		b, err := cli.DownloadIf(context.Background(), "s3://bucket/config.json", time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+
+		b, err = cli.DownloadIf(ctx, "s3://bucket/config.json", time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+	}
+
+	{ // Without the key, the modification time is used
+		putObject("plain.json", "v2", "")
+		b, err := cli.DownloadIf(context.Background(), "s3://bucket/plain.json", time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+	}
+}
+
+func TestDownloadResumable(t *testing.T) {
+	original := make([]byte, 64<<10)
+	changed := make([]byte, 64<<10)
+	rand.Read(original)
+	rand.Read(changed)
+
+	for _, tc := range []struct {
+		name   string
+		change bool   // Whether the object changes when the transfer fails
+		expect []byte // The expected contents
+		gets   int    // The expected number of GET requests
+	}{
+		{name: "resumed", expect: original, gets: 5},
+		{name: "restarted", change: true, expect: changed, gets: 7},
+	} {
+		s3 := new(fakeS3)
+		s3.Objects = make(map[string]object)
+		s3.PutObject("model.bin", original)
+
+		// The second part is truncated mid-transfer, once
+		var gets int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				if gets++; gets == 2 {
+					if tc.change {
+						defer func() {
+							s3.Lock()
+							defer s3.Unlock()
+							s3.PutObject("model.bin", changed)
+						}()
+					}
+					w = &truncatedWriter{ResponseWriter: w, limit: 1000}
+				}
+			}
+			s3.serve(w, r)
+		}))
+
+		cli, err := New(ts.URL, 1)
+		assert.NoError(t, err)
+		cli.downloader.PartSize = 16 << 10
+
+		buffer := aws.NewWriteAtBuffer(nil)
+		assert.NoError(t, cli.DownloadResumable(context.Background(), "s3://bucket/model.bin", buffer), tc.name)
+		assert.Equal(t, tc.expect, buffer.Bytes(), tc.name)
+		assert.Equal(t, tc.gets, gets, tc.name)
+		ts.Close()
+	}
+}
+
+func TestDownloadResumableMissing(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	ts := httptest.NewServer(http.HandlerFunc(s3.serve))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 1)
+	assert.NoError(t, err)
+
+	err = cli.DownloadResumable(context.Background(), "s3://bucket/missing.bin", aws.NewWriteAtBuffer(nil))
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+}
+
+func TestDownloadResumableCancel(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	s3.PutObject("model.bin", make([]byte, 64<<10))
+
+	// Every part fails without any progress
+	var gets int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s3.serve(w, r)
+	}))
+	defer ts.Close()
+
+	cli, err := New(ts.URL, 0)
+	assert.NoError(t, err)
+
+	// The attempts are spaced out, so the context expires before they are exhausted
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	err = cli.DownloadResumable(ctx, "s3://bucket/model.bin", aws.NewWriteAtBuffer(nil))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&gets))
+}
+
+// truncatedWriter drops the connection once the limit of bytes is written
+type truncatedWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *truncatedWriter) Write(b []byte) (int, error) {
+	if len(b) <= w.limit {
+		w.limit -= len(b)
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.ResponseWriter.Write(b[:w.limit])
+	w.ResponseWriter.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestNewWithTuning(t *testing.T) {
+	sess, err := session.NewSession(newConfig("eu-west-1", 1))
+	assert.NoError(t, err)
+
+	cli := NewWithTuning(sess, 3, 16<<20)
+	assert.Equal(t, 3, cli.downloader.Concurrency)
+	assert.Equal(t, int64(16<<20), cli.downloader.PartSize)
+
+	// Zero values keep the defaults
+	cli = NewWithTuning(sess, 0, 0)
+	assert.Equal(t, runtime.NumCPU()*4, cli.downloader.Concurrency)
+	assert.Equal(t, int64(s3manager.DefaultDownloadPartSize), cli.downloader.PartSize)
+}
+
+func TestPathStyle(t *testing.T) {
+	for _, region := range []string{"eu-west-1", "http://localhost:9000"} {
+		sess, err := session.NewSession(newConfig(region, 1))
+		assert.NoError(t, err)
+
+		// By default, only a custom endpoint uses the path-style addressing
+		cli := NewFromSession(sess)
+		assert.Equal(t, region != "eu-west-1", aws.BoolValue(cli.client.Config.S3ForcePathStyle), region)
+
+		for _, enabled := range []bool{true, false} {
+			cli := NewFromSession(sess, WithPathStyle(enabled))
+			assert.Equal(t, enabled, aws.BoolValue(cli.client.Config.S3ForcePathStyle), region)
+
+			// The bucket is either in the path or in the host name
+			req, _ := cli.client.GetObjectRequest(&s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("key.txt"),
+			})
+			assert.NoError(t, req.Build())
+			assert.Equal(t, enabled, strings.HasPrefix(req.HTTPRequest.URL.Path, "/bucket/"), region)
+			assert.Equal(t, !enabled, strings.HasPrefix(req.HTTPRequest.URL.Host, "bucket."), region)
+		}
+	}
+}
+
+func TestNewCompatible(t *testing.T) {
+	endpoint := "https://0123456789abcdef.r2.cloudflarestorage.com"
+	cli, err := NewCompatible(endpoint, "auto", "access", "secret", true)
+	assert.NoError(t, err)
+
+	config := cli.client.Config
+	assert.Equal(t, "auto", aws.StringValue(config.Region))
+	assert.Equal(t, endpoint, aws.StringValue(config.Endpoint))
+	assert.True(t, aws.BoolValue(config.S3ForcePathStyle))
+	assert.Equal(t, endpoint, cli.client.Endpoint)
+
+	creds, err := config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "access", creds.AccessKeyID)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+
+	// Virtual-host addressing with the default region
+	cli, err = NewCompatible("https://nyc3.digitaloceanspaces.com", "", "access", "secret", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", aws.StringValue(cli.client.Config.Region))
+	assert.False(t, aws.BoolValue(cli.client.Config.S3ForcePathStyle))
+}
+
+func TestCustomerKey(t *testing.T) {
+	s3 := new(fakeS3)
+	s3.Objects = make(map[string]object)
+	s3.PutObject("secret.txt", []byte("hello world"))
+
+	var requests []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, fmt.Sprintf("%s %s %s %s", r.Method,
+			r.Header.Get("x-amz-server-side-encryption-customer-algorithm"),
+			r.Header.Get("x-amz-server-side-encryption-customer-key"),
+			r.Header.Get("x-amz-server-side-encryption-customer-key-md5"),
+		))
+		s3.serve(w, r)
+	}))
+	defer ts.Close()
+
+	t.Setenv("AWS_CA_BUNDLE", "") // Trust the test server only
+	sess, err := session.NewSession(newConfig(ts.URL, 1).WithHTTPClient(ts.Client()))
+	assert.NoError(t, err)
+
+	key := strings.Repeat("k", 32)
+	cli := NewFromSession(sess, WithCustomerKey("AES256", key))
+	val, err := cli.DownloadIf(context.Background(), "s3://bucket/secret.txt", time.Unix(0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(val))
+
+	// Both the HEAD and the GET requests carry the key
+	hash := md5.Sum([]byte(key))
+	expect := fmt.Sprintf("AES256 %s %s",
+		base64.StdEncoding.EncodeToString([]byte(key)),
+		base64.StdEncoding.EncodeToString(hash[:]),
+	)
+	assert.Equal(t, []string{"HEAD " + expect, "GET " + expect}, requests)
+}
+
+func TestProviderOf(t *testing.T) {
+	sess, err := session.NewSession(newConfig("eu-west-1", 1))
+	assert.NoError(t, err)
+
+	{ // Default chain
+		assert.Nil(t, providerOf(sess, Options{}))
+	}
+
+	{ // Named profile
+		provider := providerOf(sess, Options{Profile: "test"})
+		assert.IsType(t, &credentials.SharedCredentialsProvider{}, provider)
+		assert.Equal(t, "test", provider.(*credentials.SharedCredentialsProvider).Profile)
+	}
+
+	{ // Assumed role
+		provider := providerOf(sess, Options{RoleARN: "arn:aws:iam::123456789012:role/reader"})
+		assert.IsType(t, &stscreds.AssumeRoleProvider{}, provider)
+		assert.Nil(t, provider.(*stscreds.AssumeRoleProvider).ExternalID)
+	}
+
+	{ // Assumed role with a profile and an external ID
+		provider := providerOf(sess, Options{
+			Profile:    "test",
+			RoleARN:    "arn:aws:iam::123456789012:role/reader",
+			ExternalID: "external",
+		})
+		assert.IsType(t, &stscreds.AssumeRoleProvider{}, provider)
+		role := provider.(*stscreds.AssumeRoleProvider)
+		assert.Equal(t, "arn:aws:iam::123456789012:role/reader", role.RoleARN)
+		assert.Equal(t, "external", *role.ExternalID)
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri, bucket, key string
+	}{
+		{"s3://bucket/key.txt", "bucket", "key.txt"},
+		{"s3://my.data.bucket/dir/key.txt", "my.data.bucket", "dir/key.txt"},
+		{"https://bucket.s3.amazonaws.com/key.txt", "bucket", "key.txt"},
+		{"https://my.data.bucket.s3.amazonaws.com/key.txt", "my.data.bucket", "key.txt"},
+		{"https://my.data.bucket.s3.eu-west-1.amazonaws.com/key.txt", "my.data.bucket", "key.txt"},
+		{"https://my.bucket.s3-us-west-2.amazonaws.com/key.txt", "my.bucket", "key.txt"},
+		{"https://s3.amazonaws.com/my.data.bucket/dir/key.txt", "my.data.bucket", "dir/key.txt"},
+		{"https://s3.eu-west-1.amazonaws.com/bucket/key.txt", "bucket", "key.txt"},
+	} {
+		bucket, key, err := parseURI(tc.uri)
+		assert.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.bucket, bucket, tc.uri)
+		assert.Equal(t, tc.key, key, tc.uri)
+	}
 }
 
 // fakeS3 represents a fake s3 server
@@ -51,6 +722,7 @@ type object struct {
 	Key        string
 	ModifiedAt int64
 	Value      []byte
+	Metadata   map[string]string
 }
 
 // serve called on every HTTP request
@@ -61,10 +733,12 @@ func (s *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.Method == http.MethodHead:
 		s.HeadObject(w, r)
-	case r.Method == http.MethodGet && strings.Contains(r.URL.String(), "list-type=2&prefix"):
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
 		s.ListObjects(w, r)
 	case r.Method == http.MethodGet:
 		s.GetObject(w, r)
+	case r.Method == http.MethodPut:
+		s.PutObject(keyOf(r), valueOf(r))
 	default:
 		w.WriteHeader(http.StatusNotImplemented)
 	}
@@ -112,11 +786,24 @@ func (s *fakeS3) PutObject(key string, value []byte) {
 	}
 }
 
+// Touch shifts the modification time of an object
+func (s *fakeS3) Touch(key string, offset time.Duration) {
+	o := s.Objects[key]
+	o.ModifiedAt = time.Now().Add(offset).UnixNano()
+	s.Objects[key] = o
+}
+
 // HeadObject emulates s3 head object
 func (s *fakeS3) HeadObject(w http.ResponseWriter, r *http.Request) {
 	key := keyOf(r)
-	if _, ok := s.Objects[key]; ok {
+	if o, ok := s.Objects[key]; ok {
 		w.Header().Set("Last-Modified", time.Now().UTC().Format(time.RFC850))
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(o.Value)))
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.Value)))
+		for k, v := range o.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -128,7 +815,8 @@ func (s *fakeS3) HeadObject(w http.ResponseWriter, r *http.Request) {
 func (s *fakeS3) GetObject(w http.ResponseWriter, r *http.Request) {
 	key := keyOf(r)
 	if o, ok := s.Objects[key]; ok {
-		w.Write(o.Value)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(o.Value)))
+		http.ServeContent(w, r, key, time.Unix(0, o.ModifiedAt), bytes.NewReader(o.Value))
 		return
 	}
 
@@ -136,8 +824,8 @@ func (s *fakeS3) GetObject(w http.ResponseWriter, r *http.Request) {
 }
 
 func keyOf(r *http.Request) string {
-	url := r.URL.String()
-	return url[2+strings.Index(url[1:], "/"):]
+	path := r.URL.Path
+	return path[2+strings.Index(path[1:], "/"):]
 }
 
 func valueOf(r *http.Request) []byte {
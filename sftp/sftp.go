@@ -0,0 +1,180 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package sftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kelindar/loader/resource"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Options represents the options of the SFTP client, such as the credentials to use.
+type Options struct {
+	User            string              // The user name, unless specified in the URI
+	Password        string              // The password, if any
+	KeyFile         string              // The path to the private key file, if any
+	HostKeyCallback ssh.HostKeyCallback // The host key verification, defaults to ~/.ssh/known_hosts
+	Timeout         time.Duration       // The timeout for establishing a connection
+}
+
+// Client represents the client implementation for the SFTP downloader.
+type Client struct {
+	config *ssh.ClientConfig
+}
+
+// New creates a new SFTP client with the specified options.
+func New(options Options) (*Client, error) {
+	var auth []ssh.AuthMethod
+	if options.KeyFile != "" {
+		key, err := os.ReadFile(options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if options.Password != "" {
+		auth = append(auth, ssh.Password(options.Password))
+	}
+
+	// Verify the host keys against the known hosts by default
+	if options.HostKeyCallback == nil {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		if options.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Client{
+		config: &ssh.ClientConfig{
+			User:            options.User,
+			Auth:            auth,
+			HostKeyCallback: options.HostKeyCallback,
+			Timeout:         options.Timeout,
+		},
+	}, nil
+}
+
+// DownloadIf downloads a file only if the updatedSince time is older than the resource
+// timestamp itself.
+func (c *Client) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	b, _, err := c.DownloadMeta(ctx, uri, updatedSince)
+	return b, err
+}
+
+// DownloadMeta downloads a file only if the updatedSince time is older than the resource
+// timestamp itself and returns the metadata of the file along with its contents.
+func (c *Client) DownloadMeta(ctx context.Context, uri string, updatedSince time.Time) ([]byte, resource.Meta, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	client, err := c.connect(ctx, u)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	defer client.Close()
+
+	// Get the file information
+	fi, err := client.Stat(u.Path)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	// No updates have happened since the provided date
+	if !isModified(fi.ModTime(), updatedSince) {
+		return nil, resource.Meta{}, nil
+	}
+
+	f, err := client.Open(u.Path)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, resource.Meta{}, err
+	}
+
+	return b, resource.Meta{
+		LastModified: fi.ModTime(),
+		Size:         int64(len(b)),
+	}, nil
+}
+
+// session represents an SFTP session along with its underlying SSH connection
+type session struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// Close closes both the SFTP session and the SSH connection
+func (s *session) Close() error {
+	s.Client.Close()
+	return s.conn.Close()
+}
+
+// connect establishes a new SFTP session with the host of the URI
+func (c *Client) connect(ctx context.Context, u *url.URL) (*session, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	config := *c.config
+	if u.User != nil && u.User.Username() != "" {
+		config.User = u.User.Username()
+	}
+
+	// Dial the host, honoring the cancellation of the context
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, &config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return &session{Client: client, conn: sshClient}, nil
+}
+
+func isModified(updatedAt, updatedSince time.Time) bool {
+	return updatedAt.After(updatedSince)
+}
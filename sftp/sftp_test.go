@@ -0,0 +1,146 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTP(t *testing.T) {
+	server := newFakeSFTP(t, "user", "secret")
+	defer server.Close()
+
+	f := filepath.Join(t.TempDir(), "test.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hello world"), 0644))
+	url := fmt.Sprintf("sftp://user@%s%s", server.Addr(), filepath.ToSlash(f))
+
+	client, err := New(Options{
+		Password:        "secret",
+		HostKeyCallback: ssh.FixedHostKey(server.hostKey),
+	})
+	assert.NoError(t, err)
+
+	{ // Modified
+		b, meta, err := client.DownloadMeta(context.Background(), url, time.Unix(0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(b))
+		assert.Equal(t, int64(11), meta.Size)
+		assert.False(t, meta.LastModified.IsZero())
+	}
+
+	{ // Not modified
+		b, err := client.DownloadIf(context.Background(), url, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+
+	{ // Missing file
+		_, err := client.DownloadIf(context.Background(), url+".missing", time.Unix(0, 0))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestSFTPUnauthorized(t *testing.T) {
+	server := newFakeSFTP(t, "user", "secret")
+	defer server.Close()
+
+	client, err := New(Options{
+		User:            "user",
+		Password:        "wrong",
+		HostKeyCallback: ssh.FixedHostKey(server.hostKey),
+	})
+	assert.NoError(t, err)
+
+	_, err = client.DownloadIf(context.Background(), fmt.Sprintf("sftp://%s/test.txt", server.Addr()), time.Unix(0, 0))
+	assert.Error(t, err)
+}
+
+// fakeSFTP represents an in-process SFTP server serving the local file system
+type fakeSFTP struct {
+	net.Listener
+	hostKey ssh.PublicKey
+}
+
+// newFakeSFTP starts a new SFTP server which accepts the specified password
+func newFakeSFTP(t *testing.T, user, password string) *fakeSFTP {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied for %s", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return &fakeSFTP{
+		Listener: listener,
+		hostKey:  signer.PublicKey(),
+	}
+}
+
+// serveSFTP serves the sftp subsystem over a single connection
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+
+		go func() {
+			defer channel.Close()
+			if server, err := sftp.NewServer(channel); err == nil {
+				server.Serve()
+			}
+		}()
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"sync"
+	"time"
+)
+
+// stagger spaces out the first checks of the watchers started in a burst, so that registering
+// many watchers at once does not hit the backends all at the same time.
+type stagger struct {
+	lock sync.Mutex
+	step time.Duration // The offset between two consecutive first checks
+	next time.Time     // The earliest time of the next first check
+}
+
+// newStagger creates a new stagger with the offset between the first checks
+func newStagger(step time.Duration) *stagger {
+	return &stagger{step: step}
+}
+
+// delay returns how long the first check of a new watcher is delayed. Each watcher is delayed
+// by one more step than the previous one, until the burst is over.
+func (s *stagger) delay(now time.Time) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+
+	s.next = at.Add(s.step)
+	return at.Sub(now)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagger(t *testing.T) {
+	s := newStagger(10 * time.Millisecond)
+	now := time.Unix(1000, 0)
+
+	// Each watcher of the burst is delayed by one more step
+	assert.Equal(t, time.Duration(0), s.delay(now))
+	assert.Equal(t, 10*time.Millisecond, s.delay(now))
+	assert.Equal(t, 20*time.Millisecond, s.delay(now))
+	assert.Equal(t, 15*time.Millisecond, s.delay(now.Add(15*time.Millisecond)))
+
+	// Once the burst is over, there is no delay
+	assert.Equal(t, time.Duration(0), s.delay(now.Add(time.Second)))
+}
+
+func TestWatchStartupStagger(t *testing.T) {
+	dl := &timingDownloader{checks: make(map[string]time.Time)}
+	loader := New(WithDownloader("test", dl), WithStartupStagger(30*time.Millisecond))
+
+	// Watch returns right away, without waiting for the first check
+	start := time.Now()
+	var updates []<-chan Update
+	for i := 0; i < 4; i++ {
+		updates = append(updates, loader.Watch(context.Background(), fmt.Sprintf("test://%d", i), time.Hour))
+	}
+	assert.Less(t, time.Since(start), 30*time.Millisecond)
+
+	// The first checks are spaced out in the order of registration
+	for _, ch := range updates {
+		<-ch
+	}
+
+	for i := 1; i < 4; i++ {
+		prev := dl.firstCheck(fmt.Sprintf("test://%d", i-1))
+		next := dl.firstCheck(fmt.Sprintf("test://%d", i))
+		assert.GreaterOrEqual(t, next.Sub(prev), 20*time.Millisecond, i)
+	}
+
+	for i := 0; i < 4; i++ {
+		loader.Unwatch(fmt.Sprintf("test://%d", i))
+	}
+}
+
+func TestWatchStartupStaggerUnwatch(t *testing.T) {
+	dl := &timingDownloader{checks: make(map[string]time.Time)}
+	loader := New(WithDownloader("test", dl), WithStartupStagger(time.Hour))
+	loader.Watch(context.Background(), "test://0", time.Hour)
+	updates := loader.Watch(context.Background(), "test://1", time.Hour)
+
+	// Stopping a watcher which did not check yet
+	assert.True(t, loader.Unwatch("test://1"))
+	for range updates {
+	}
+
+	assert.True(t, dl.firstCheck("test://1").IsZero())
+	assert.True(t, loader.Unwatch("test://0"))
+}
+
+// timingDownloader records the time of the first check of every resource
+type timingDownloader struct {
+	sync.Mutex
+	checks map[string]time.Time
+}
+
+func (d *timingDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.checks[uri]; !ok {
+		d.checks[uri] = time.Now()
+	}
+	return []byte(uri), nil
+}
+
+func (d *timingDownloader) firstCheck(uri string) time.Time {
+	d.Lock()
+	defer d.Unlock()
+	return d.checks[uri]
+}
@@ -5,10 +5,18 @@ package loader
 
 import (
 	"context"
-	"log"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
 	"runtime/debug"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/kelindar/loader/resource"
 )
 
 // Various watcher states
@@ -19,21 +27,81 @@ const (
 	isDisposed
 )
 
+// stateNames maps the watcher states to their names
+var stateNames = map[int32]string{
+	isCreated:  "created",
+	isRunning:  "running",
+	isCanceled: "canceled",
+	isDisposed: "disposed",
+}
+
 // Update represents a single update event
 type Update struct {
-	Data []byte // The file contents downloaded
-	Err  error  // The error that has occurred during an update
+	Data    []byte // The file contents downloaded
+	Size    int    // The number of bytes downloaded
+	Err     error  // The error that has occurred during an update
+	Meta    Meta   // The metadata of the resource, if available
+	Deleted bool   // Whether the resource was deleted since the last update
+}
+
+// WatcherStatus represents the status of a watcher, for example to report the health
+// of the polling.
+type WatcherStatus struct {
+	LastUpdated time.Time // The time of the last update, zero if never updated
+	LastError   error     // The error of the most recent check, nil if it succeeded
+	State       string    // The state of the watcher (created, running, canceled or disposed)
 }
 
+// Bounds of the randomized check interval
+const (
+	maxJitter   = 0.5                    // The maximum fraction of the interval used as jitter
+	minInterval = 1 * time.Millisecond   // The minimum interval between the checks
+	minPolling  = 100 * time.Millisecond // The interval used in place of a non-positive one
+)
+
+// maxRestarts is the number of times the restart delay is doubled at most
+const maxRestarts = 6
+
 // Watcher represents a watcher instance that monitors a single uri
 type watcher struct {
 	state     int32         // The state machine of the watcher
 	updatedAt int64         // The last updated time
+	lock      sync.Mutex    // The lock for the last error, the last update and the subscribers
+	lastErr   error         // The error of the most recent check
+	last      *Update       // The last update pushed out, replayed to the new subscribers
+	lastHash  [32]byte      // The hash of the last emitted contents, for deduplication
+	lastMeta  Meta          // The metadata of the last version loaded, to validate the next one
 	loader    *Loader       // The parent loader to use
 	uri       string        // The uri to watch
-	updates   chan Update   // The update channel
-	interval  time.Duration // Interval between subsequent check calls
+	subs      []subscriber  // The subscribers, in the order of subscription
+	nudge     chan struct{} // The signal to check immediately
+	done      chan struct{} // Closed once the watcher is stopped
+	retime    chan struct{} // The signal that the interval was changed
+	interval  int64         // Interval between subsequent check calls, in nanoseconds
 	onStop    func()        // User-defined cancellation callback
+	pending   *Update       // The update held back until the debounce period elapses
+	flushAt   time.Time     // The time at which the update held back is pushed out
+	failures  int           // The number of consecutive failed checks
+	restarts  int           // The number of restarts since the last successful check
+	retryAt   time.Time     // The time before which the server asked not to check again
+	exists    bool          // Whether the resource was loaded and not deleted since
+	deleted   bool          // Whether the deletion of the resource was reported
+}
+
+// subscriber represents a single subscriber of a watcher
+type subscriber struct {
+	updates chan Update // The channel of updates of the subscriber
+	stop    func() bool // Stops the unsubscription when the context of the subscriber is done
+	release func()      // Releases the resources of the subscriber once its channel is closed
+}
+
+// close closes the channel of the subscriber and releases its resources
+func (s *subscriber) close() {
+	s.stop()
+	close(s.updates)
+	if s.release != nil {
+		s.release()
+	}
 }
 
 // newWatcher creates a new watcher
@@ -43,20 +111,108 @@ func newWatcher(loader *Loader, uri string, interval time.Duration, onStop func(
 		updatedAt: 0,
 		loader:    loader,
 		uri:       uri,
-		updates:   make(chan Update, 1),
-		interval:  interval,
+		nudge:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		retime:    make(chan struct{}, 1),
+		interval:  int64(intervalOf(interval)),
 		onStop:    onStop,
 	}
 }
 
-// Start starts watching
+// Start starts watching. Since the watcher is shared by its subscribers, it does not stop when
+// the context is cancelled, but once the last subscriber leaves.
 func (w *watcher) Start(ctx context.Context) {
 	if !w.changeState(isCreated, isRunning) {
 		return // Prevent from starting twice
 	}
 
-	w.check(ctx)
-	go w.checkLoop(ctx)
+	ctx = context.WithoutCancel(ctx)
+	delay := w.firstDelay()
+	if delay <= 0 {
+		w.check(ctx)
+		go w.checkLoop(ctx)
+		return
+	}
+
+	// Delay the first check, unless stopped in the meantime
+	go func() {
+		select {
+		case <-w.done:
+			return
+		case <-w.loader.clock.After(delay):
+			w.check(ctx)
+			w.checkLoop(ctx)
+		}
+	}()
+}
+
+// firstDelay returns how long the first check is delayed, if the watchers are staggered
+func (w *watcher) firstDelay() time.Duration {
+	if w.loader.stagger == nil {
+		return 0
+	}
+	return w.loader.stagger.delay(w.loader.clock.Now())
+}
+
+// subscribe adds a subscriber which receives every update on its own channel until the context
+// is done, and returns false if the watcher is already stopped. The last update pushed out, if
+// any, is replayed to the subscriber, so it does not have to wait for the next change. The
+// release function, if any, is invoked once the channel of the subscriber is closed.
+func (w *watcher) subscribe(ctx context.Context, release func()) (<-chan Update, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if state := atomic.LoadInt32(&w.state); state == isCanceled || state == isDisposed {
+		return nil, false
+	}
+
+	updates := make(chan Update, max(1, w.loader.buffer))
+	if w.last != nil {
+		updates <- *w.last
+	}
+
+	w.subs = append(w.subs, subscriber{
+		updates: updates,
+		stop: context.AfterFunc(ctx, func() {
+			w.unsubscribe(updates)
+		}),
+		release: release,
+	})
+	return updates, true
+}
+
+// unsubscribe removes the subscriber and closes its channel, and returns whether it was found.
+// Once the last subscriber leaves, the watcher is stopped.
+func (w *watcher) unsubscribe(updates <-chan Update) bool {
+	w.lock.Lock()
+	i := slices.IndexFunc(w.subs, func(sub subscriber) bool {
+		return sub.updates == updates
+	})
+	if i < 0 {
+		w.lock.Unlock()
+		return false
+	}
+
+	w.subs[i].close()
+	w.subs = slices.Delete(w.subs, i, i+1)
+	empty := len(w.subs) == 0
+	w.lock.Unlock()
+
+	if empty {
+		w.Close()
+	}
+	return true
+}
+
+// unsubscribeLast removes the most recent subscriber, and returns whether there was any
+func (w *watcher) unsubscribeLast() bool {
+	w.lock.Lock()
+	var updates <-chan Update
+	if n := len(w.subs); n > 0 {
+		updates = w.subs[n-1].updates
+	}
+	w.lock.Unlock()
+
+	return updates != nil && w.unsubscribe(updates)
 }
 
 // Check performs a single check
@@ -70,55 +226,349 @@ func (w *watcher) check(ctx context.Context) {
 	}
 
 	// Timeout only applies for this attempt to fetch,
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	ctx, cancel := context.WithTimeout(ctx, w.loader.timeout)
 	defer cancel()
-	defer handlePanic()
+	defer w.handlePanic()
+
+	// Check and load, validated against the last version loaded by this watcher
+	now := w.loader.clock.Now()
+	b, meta, err := w.loader.LoadWithMeta(resource.LastSeen(ctx, w.lastMeta), w.uri, w.since())
+	if w.loader.required && atomic.LoadInt64(&w.updatedAt) == 0 {
+		err = requireFound(b, err)
+	}
+
+	w.setLastError(err)
+	if err != nil {
+		w.failures++
+	} else {
+		w.failures, w.restarts = 0, 0
+	}
+
+	// Respect the delay requested by the server, if any
+	w.retryAt = time.Time{}
+	if delay := retryAfterOf(err); delay > 0 {
+		w.retryAt = now.Add(delay)
+	}
 
-	// Check and load
-	now := time.Now()
-	b, err := w.loader.LoadIf(ctx, w.uri, w.updatedAtTime())
-	if b == nil && err == nil {
+	// The deletion of a resource which was loaded before is only reported once
+	missing := errors.Is(err, fs.ErrNotExist)
+	deleted := missing && w.exists
+	if (b == nil && err == nil) || (missing && w.deleted) {
+		w.loader.observer.OnWatchEvent(w.uri, false)
 		return // No updates, skip
 	}
 
-	// Update the time and push the update out
-	atomic.StoreInt64(&w.updatedAt, now.UnixNano())
-	w.updates <- Update{b, err}
+	switch {
+	case err == nil:
+		w.exists, w.deleted = true, false
+		w.lastMeta = meta
+	case deleted:
+		w.exists, w.deleted = false, true
+		w.lastHash, w.lastMeta = [32]byte{}, Meta{}
+	}
+
+	// Update the time and skip if the contents are identical to the last update
+	first := atomic.SwapInt64(&w.updatedAt, now.UnixNano()) == 0
+	if w.loader.dedup && err == nil {
+		hash := sha256.Sum256(b)
+		if hash == w.lastHash {
+			w.loader.observer.OnWatchEvent(w.uri, false)
+			return
+		}
+		w.lastHash = hash
+	}
+
+	// Push the update out
+	w.loader.observer.OnWatchEvent(w.uri, err == nil)
+	w.emit(Update{Data: b, Size: len(b), Err: err, Meta: meta, Deleted: deleted}, first)
+}
+
+// requireFound returns ErrNotFound if the resource is missing or nothing was loaded
+func requireFound(b []byte, err error) error {
+	switch {
+	case b == nil && err == nil:
+		return ErrNotFound
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	default:
+		return err
+	}
+}
+
+// emit pushes the update out. If debouncing is enabled, the update is held back until no
+// other change is detected for the debounce period, except for the very first update.
+func (w *watcher) emit(update Update, immediate bool) {
+	delay := w.loader.debounce
+	if delay <= 0 || immediate {
+		w.send(update)
+		return
+	}
+
+	// Hold the update back and restart the quiet period
+	w.pending = &update
+	w.flushAt = w.loader.clock.Now().Add(delay)
+}
+
+// flush pushes out the update held back by the debounce, if any
+func (w *watcher) flush() {
+	if w.pending != nil {
+		update := *w.pending
+		w.pending = nil
+		w.send(update)
+	}
+}
+
+// send pushes the update out to every subscriber, unless the watcher is stopped. The channels
+// are only closed under the lock once the done channel is closed, so a check racing with the
+// stop never sends on a closed channel and does not rely on recovering from a panic.
+func (w *watcher) send(update Update) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	select {
+	case <-w.done:
+		return // Stopped, the update is dropped
+	default:
+	}
+
+	w.last = &update
+	for _, sub := range w.subs {
+		push(sub.updates, update)
+	}
+}
+
+// push pushes the update into the channel without blocking. If the consumer is lagging behind
+// and the buffer is full, the oldest update is dropped in favor of the latest one, so a stalled
+// consumer can never block the polling.
+func push(updates chan Update, update Update) {
+	for {
+		select {
+		case updates <- update:
+			return
+		default:
+		}
+
+		// Drop the oldest update to make room
+		select {
+		case <-updates:
+		default:
+		}
+	}
+}
+
+// flushed returns the channel which fires once the debounce period elapses, or nil if
+// there is no update held back.
+func (w *watcher) flushed() <-chan time.Time {
+	if w.pending == nil {
+		return nil
+	}
+	return w.loader.clock.After(w.flushAt.Sub(w.loader.clock.Now()))
 }
 
-// checkLoop calls check on a timer
+// checkLoop calls check on a fixed cadence until the watcher is stopped, regardless of the
+// context which is detached by Start. Since checks run on this goroutine, the checks that are
+// due while a check is still in progress are dropped rather than queued.
 func (w *watcher) checkLoop(ctx context.Context) {
+	clock := w.loader.clock
+	period := w.nextInterval()
+	next := w.notBefore(clock.Now().Add(period))
+
 	for atomic.LoadInt32(&w.state) == isRunning {
+		if w.exhausted() {
+			if !w.restart() {
+				return
+			}
+
+			// Start afresh, as if the watcher was just started
+			w.check(ctx)
+			period = w.nextInterval()
+			next = w.notBefore(clock.Now().Add(period))
+			continue
+		}
+
 		select {
-		case <-ctx.Done():
-			w.Close()
-			w.dispose()
+		case <-w.done:
 			return
-		default:
+		case <-clock.After(next.Sub(clock.Now())):
+			w.check(ctx)
+
+			// Keep the cadence, unless the interval changes
+			now := clock.Now()
+			if interval := w.nextInterval(); interval != period {
+				period, next = interval, now.Add(interval)
+			}
+
+			for !next.After(now) {
+				next = next.Add(period)
+			}
+			next = w.notBefore(next)
+		case <-w.nudge:
 			w.check(ctx)
-			time.Sleep(w.interval)
+		case <-w.retime:
+			period = w.nextInterval()
+			next = w.notBefore(clock.Now().Add(period))
+		case <-w.flushed():
+			w.flush()
 		}
 	}
 }
 
-// Close stops the watcher
+// exhausted returns whether the watcher reached the maximum number of consecutive failures
+func (w *watcher) exhausted() bool {
+	return w.loader.maxFails > 0 && w.failures >= w.loader.maxFails
+}
+
+// restart waits for the restart delay once the watcher failed too many times in a row, and
+// returns whether the watcher should start again. Without the auto-restart, the watcher is
+// stopped right away instead.
+func (w *watcher) restart() bool {
+	w.flush()
+	if w.loader.restart <= 0 {
+		w.Close()
+		return false
+	}
+
+	delay := w.loader.restart
+	for i := 0; i < w.restarts && i < maxRestarts; i++ {
+		delay *= 2
+	}
+
+	select {
+	case <-w.done:
+		return false
+	case <-w.loader.clock.After(delay):
+		w.failures, w.retryAt = 0, time.Time{}
+		w.restarts++
+		return true
+	}
+}
+
+// notBefore returns the time of the next check, delayed for at least as long as the server
+// asked for on the last check, if it did.
+func (w *watcher) notBefore(next time.Time) time.Time {
+	if w.retryAt.After(next) {
+		return w.retryAt
+	}
+	return next
+}
+
+// intervalOf returns the interval between the checks, so a non-positive interval passed by
+// mistake does not make the watcher spin.
+func intervalOf(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return minPolling
+	}
+	return interval
+}
+
+// Reload makes the watcher check immediately, without waiting for the next interval. If a
+// check is already pending, the signal is coalesced with it.
+func (w *watcher) Reload() {
+	select {
+	case w.nudge <- struct{}{}:
+	default:
+	}
+}
+
+// nextInterval returns the interval until the next check, backed off on consecutive failures
+// and randomized by the jitter, if enabled.
+func (w *watcher) nextInterval() time.Duration {
+	interval := w.Interval()
+	if limit := max(w.loader.maxDelay, interval); w.loader.maxDelay > 0 {
+		for i := 0; i < w.failures && interval < limit; i++ {
+			interval *= 2
+		}
+		interval = min(interval, limit)
+	}
+
+	if w.loader.jitter <= 0 {
+		return interval
+	}
+
+	delta := (2*rand.Float64() - 1) * w.loader.jitter * float64(interval)
+	return max(interval+time.Duration(delta), minInterval)
+}
+
+// Interval returns the base interval between the checks
+func (w *watcher) Interval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&w.interval))
+}
+
+// SetInterval changes the base interval between the checks. The next check is rescheduled
+// from now on, without waiting for the check scheduled with the previous interval.
+func (w *watcher) SetInterval(interval time.Duration) {
+	atomic.StoreInt64(&w.interval, int64(intervalOf(interval)))
+	select {
+	case w.retime <- struct{}{}:
+	default: // Already signalled
+	}
+}
+
+// Close stops the watcher, even if it was not started yet
 func (w *watcher) Close() error {
-	w.changeState(isRunning, isCanceled)
+	if w.changeState(isRunning, isCanceled) || w.changeState(isCreated, isCanceled) {
+		close(w.done)
+	}
+
 	w.dispose()
 	return nil
 }
 
-// dispose closes the channel and marks the watcher as disposed
+// dispose closes the channels of the subscribers and marks the watcher as disposed
 func (w *watcher) dispose() {
-	if w.changeState(isCanceled, isDisposed) {
-		close(w.updates)
+	w.lock.Lock()
+	disposed := atomic.CompareAndSwapInt32(&w.state, isCanceled, isDisposed)
+	if disposed {
+		for _, sub := range w.subs {
+			sub.close()
+		}
+		w.subs = nil
+	}
+	w.lock.Unlock()
+
+	if disposed {
+		w.notify(isCanceled, isDisposed)
 		w.onStop()
 	}
 }
 
-// changeState changes the state of the watcher
+// changeState changes the state of the watcher and notifies the state hook, if any
 func (w *watcher) changeState(from, to int32) bool {
-	return atomic.CompareAndSwapInt32(&w.state, int32(from), int32(to))
+	if !atomic.CompareAndSwapInt32(&w.state, int32(from), int32(to)) {
+		return false
+	}
+
+	w.notify(from, to)
+	return true
+}
+
+// notify notifies the state hook of a state transition, if any
+func (w *watcher) notify(from, to int32) {
+	if w.loader.onState != nil {
+		w.loader.onState(w.uri, from, to)
+	}
+}
+
+// Status returns the current status of the watcher
+func (w *watcher) Status() WatcherStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	status := WatcherStatus{
+		LastError: w.lastErr,
+		State:     stateNames[atomic.LoadInt32(&w.state)],
+	}
+	if updatedAt := atomic.LoadInt64(&w.updatedAt); updatedAt > 0 {
+		status.LastUpdated = time.Unix(0, updatedAt)
+	}
+	return status
+}
+
+// setLastError sets the error of the most recent check
+func (w *watcher) setLastError(err error) {
+	w.lock.Lock()
+	w.lastErr = err
+	w.lock.Unlock()
 }
 
 // updatedAtTime returns a last updated time
@@ -126,9 +576,30 @@ func (w *watcher) updatedAtTime() time.Time {
 	return time.Unix(0, atomic.LoadInt64(&w.updatedAt))
 }
 
-// handlePanic handles the panic and logs it out.
-func handlePanic() {
-	if r := recover(); r != nil {
-		log.Printf("panic recovered: %s \n %s", r, debug.Stack())
+// since returns the time since which the resource is loaded. Once the resource is deleted, it
+// is loaded unconditionally, since it may be restored with an older modification time.
+func (w *watcher) since() time.Time {
+	if w.deleted {
+		return zeroTime
 	}
+	return w.updatedAtTime()
+}
+
+// handlePanic handles the panic, logs it out and reports it to the subscribers as an error, so
+// that the watcher keeps going.
+func (w *watcher) handlePanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	w.loader.logger.Printf("panic recovered: %s \n %s", r, debug.Stack())
+	if w.loader.onPanic != nil {
+		w.loader.onPanic(w.uri, r)
+	}
+
+	err := fmt.Errorf("%w: %v", ErrPanic, r)
+	w.setLastError(err)
+	w.failures++
+	w.send(Update{Err: err})
 }
@@ -5,13 +5,22 @@ package loader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/kelindar/loader/file"
+	loaderhttp "github.com/kelindar/loader/http"
+	"github.com/kelindar/loader/memory"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +37,9 @@ func TestWatch(t *testing.T) {
 	u := <-updates
 	assert.NotNil(t, u.Data)
 	assert.Nil(t, u.Err)
+	assert.Equal(t, len(u.Data), u.Size)
+	assert.Equal(t, int64(len(u.Data)), u.Meta.Size)
+	assert.False(t, u.Meta.LastModified.IsZero())
 
 	assert.True(t, loader.Unwatch(url))
 }
@@ -140,6 +152,749 @@ func TestRangeWatchers(t *testing.T) {
 	assert.Equal(t, 1, countWatchers(loader))
 }
 
+func TestWatchTimeout(t *testing.T) {
+	loader := New(
+		WithDownloader("block", new(blockingDownloader)),
+		WithTimeout(time.Millisecond),
+	)
+
+	u := <-loader.Watch(context.Background(), "block://test", time.Second)
+	assert.Nil(t, u.Data)
+	assert.ErrorIs(t, u.Err, context.DeadlineExceeded)
+	assert.True(t, loader.Unwatch("block://test"))
+}
+
+func TestWatchDedup(t *testing.T) {
+	url := writeTestFile(t, "hello")
+	path := strings.TrimPrefix(url, "file:///")
+	w := newWatcher(New(WithDedup()), url, time.Second, func() {})
+	w.changeState(isCreated, isRunning)
+
+	// First update is always emitted
+	updates, _ := w.subscribe(context.Background(), nil)
+	w.check(context.Background())
+	u := <-updates
+	assert.Equal(t, "hello", string(u.Data))
+
+	// Same contents, but a different modification time
+	touch(t, path, time.Hour)
+	w.check(context.Background())
+	assert.Len(t, updates, 0)
+
+	// Different contents
+	assert.NoError(t, os.WriteFile(path, []byte("world"), 0644))
+	touch(t, path, 2*time.Hour)
+	w.check(context.Background())
+	u = <-updates
+	assert.Equal(t, "world", string(u.Data))
+}
+
+func TestWatchCadence(t *testing.T) {
+	dl := &countingDownloader{delay: 10 * time.Millisecond}
+	loader := New(WithDownloader("count", dl))
+	loader.Watch(context.Background(), "count://test", 20*time.Millisecond)
+	time.Sleep(400 * time.Millisecond)
+	loader.Unwatch("count://test")
+
+	// With a sleep between the checks, there would be only ~13 checks
+	assert.GreaterOrEqual(t, int(atomic.LoadInt64(&dl.count)), 16)
+}
+
+func TestWatchZeroInterval(t *testing.T) {
+	dl := new(staticCounter)
+	loader := New(WithDownloader("static", dl))
+	for _, interval := range []time.Duration{0, -time.Second} {
+		uri := fmt.Sprintf("static://%v", interval)
+		<-loader.Watch(context.Background(), uri, interval)
+		time.Sleep(250 * time.Millisecond)
+		loader.Unwatch(uri)
+	}
+
+	// Checked about every 100ms, rather than spinning
+	assert.LessOrEqual(t, atomic.LoadInt64(&dl.count), int64(10))
+}
+
+func TestJitter(t *testing.T) {
+	interval := 100 * time.Millisecond
+	w := newWatcher(New(WithJitter(0.2)), "static://test", interval, func() {})
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		d := w.nextInterval()
+		assert.GreaterOrEqual(t, d, 80*time.Millisecond)
+		assert.LessOrEqual(t, d, 120*time.Millisecond)
+		seen[d] = true
+	}
+	assert.Greater(t, len(seen), 1)
+
+	// The fraction is capped, so the interval is never more than halved
+	w = newWatcher(New(WithJitter(5)), "static://test", interval, func() {})
+	for i := 0; i < 1000; i++ {
+		assert.GreaterOrEqual(t, w.nextInterval(), interval/2)
+	}
+}
+
+func TestJitterCancel(t *testing.T) {
+	loader := New(WithJitter(0.5), WithDownloader("static", staticDownloader("hello")))
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := loader.Watch(ctx, "static://test", 10*time.Millisecond)
+	<-updates
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		_, ok := loader.WatcherStatus("static://test")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMaxBackoff(t *testing.T) {
+	dl := new(failingDownloader)
+	loader := New(WithMaxBackoff(80*time.Millisecond), WithDownloader("fail", dl))
+	loader.Watch(context.Background(), "fail://test", 10*time.Millisecond)
+	time.Sleep(600 * time.Millisecond)
+	loader.Unwatch("fail://test")
+
+	// The gaps between the checks grow up to the cap
+	gaps := dl.gaps()
+	assert.GreaterOrEqual(t, len(gaps), 5)
+	assert.Less(t, gaps[0], gaps[2])
+	assert.GreaterOrEqual(t, gaps[len(gaps)-1], 70*time.Millisecond)
+	for _, gap := range gaps {
+		assert.Less(t, gap, 120*time.Millisecond)
+	}
+
+	// The interval resets on success
+	w := newWatcher(loader, "fail://test", 10*time.Millisecond, func() {})
+	w.failures = 10
+	assert.Equal(t, 80*time.Millisecond, w.nextInterval())
+	w.failures = 0
+	assert.Equal(t, 10*time.Millisecond, w.nextInterval())
+}
+
+func TestWatchMaxFailures(t *testing.T) {
+	dl := new(failingDownloader)
+	loader := New(WithMaxFailures(3), WithDownloader("fail", dl))
+
+	// The channel is closed after the third failed check
+	var errs int
+	for u := range loader.Watch(context.Background(), "fail://test", 5*time.Millisecond) {
+		assert.Error(t, u.Err)
+		errs++
+	}
+
+	assert.Equal(t, 3, errs)
+	assert.Len(t, dl.gaps(), 2)
+	_, ok := loader.WatcherStatus("fail://test")
+	assert.False(t, ok)
+}
+
+func TestWatchAutoRestart(t *testing.T) {
+	dl := &flakyDownloader{failures: 4}
+	loader := New(WithMaxFailures(2), WithAutoRestart(30*time.Millisecond), WithDownloader("flaky", dl))
+
+	// Fails twice, waits 30ms, fails twice again, waits 60ms and recovers
+	start := time.Now()
+	for u := range loader.Watch(context.Background(), "flaky://test", 5*time.Millisecond) {
+		if u.Err == nil {
+			assert.Equal(t, "hello", string(u.Data))
+			break
+		}
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&dl.calls))
+	status, ok := loader.WatcherStatus("flaky://test")
+	assert.True(t, ok)
+	assert.Equal(t, "running", status.State)
+	loader.Unwatch("flaky://test")
+}
+
+func TestStateHook(t *testing.T) {
+	var lock sync.Mutex
+	var states []string
+	loader := New(
+		WithDownloader("static", staticDownloader("hello")),
+		WithStateHook(func(uri string, from, to int32) {
+			lock.Lock()
+			defer lock.Unlock()
+			states = append(states, stateNames[from]+"->"+stateNames[to])
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := loader.Watch(ctx, "static://test", 10*time.Millisecond)
+	<-updates
+	cancel()
+
+	for range updates {
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"created->running", "running->canceled", "canceled->disposed"}, states)
+}
+
+func TestWatchFunc(t *testing.T) {
+	var count int64
+	loader := New(WithDownloader("static", staticDownloader("hello")))
+	cancel := loader.WatchFunc(context.Background(), "static://test", 10*time.Millisecond, func(u Update) {
+		assert.Equal(t, "hello", string(u.Data))
+		atomic.AddInt64(&count, 1)
+	})
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&count) >= 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Once cancelled, the callback should no longer be invoked
+	cancel()
+	assert.Eventually(t, func() bool {
+		return countWatchers(loader) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	stoppedAt := atomic.LoadInt64(&count)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stoppedAt, atomic.LoadInt64(&count))
+}
+
+func TestWatcherStatus(t *testing.T) {
+	loader, url := makeTestLoader()
+	_, ok := loader.WatcherStatus(url)
+	assert.False(t, ok)
+
+	{ // Successful update
+		<-loader.Watch(context.Background(), url, time.Minute)
+		status, ok := loader.WatcherStatus(url)
+		assert.True(t, ok)
+		assert.Equal(t, "running", status.State)
+		assert.False(t, status.LastUpdated.IsZero())
+		assert.NoError(t, status.LastError)
+	}
+
+	{ // Failed update
+		missing := "file:///" + filepath.Join(t.TempDir(), "missing.txt")
+		u := <-loader.Watch(context.Background(), missing, time.Minute)
+		status, ok := loader.WatcherStatus(missing)
+		assert.True(t, ok)
+		assert.Equal(t, "running", status.State)
+		assert.Equal(t, u.Err, status.LastError)
+		assert.True(t, loader.Unwatch(missing))
+	}
+
+	loader.Unwatch(url)
+	_, ok = loader.WatcherStatus(url)
+	assert.False(t, ok)
+}
+
+func TestWatchDeleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte("v1"), 0644))
+
+	url := "file:///" + path
+	loader := New()
+	updates := loader.Watch(context.Background(), url, 10*time.Millisecond)
+	defer loader.Unwatch(url)
+
+	u := <-updates
+	assert.Equal(t, "v1", string(u.Data))
+	assert.False(t, u.Deleted)
+
+	// Deleting the file is reported once, with a distinct flag
+	assert.NoError(t, os.Remove(path))
+	u = <-updates
+	assert.True(t, u.Deleted)
+	assert.ErrorIs(t, u.Err, fs.ErrNotExist)
+
+	select {
+	case u := <-updates:
+		assert.Fail(t, "unexpected update", "%+v", u)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Restoring the file, even with an older modification time, is picked up again
+	backup := path + ".bak"
+	assert.NoError(t, os.WriteFile(backup, []byte("v2"), 0644))
+	assert.NoError(t, os.Chtimes(backup, time.Unix(1, 0), time.Unix(1, 0)))
+	assert.NoError(t, os.Rename(backup, path))
+	u = <-updates
+	assert.Equal(t, "v2", string(u.Data))
+	assert.False(t, u.Deleted)
+	assert.NoError(t, u.Err)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte("v1"), 0644))
+
+	url := "file:///" + path
+	loader := New()
+	assert.False(t, loader.Reload(url))
+
+	updates := loader.Watch(context.Background(), url, time.Hour)
+	defer loader.Unwatch(url)
+	assert.Equal(t, "v1", string((<-updates).Data))
+
+	// Modify the file and reload, well before the interval elapses
+	assert.NoError(t, os.WriteFile(path, []byte("v2"), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	assert.True(t, loader.Reload(url))
+
+	select {
+	case u := <-updates:
+		assert.Equal(t, "v2", string(u.Data))
+	case <-time.After(time.Second):
+		assert.Fail(t, "reload timed out")
+	}
+}
+
+func TestWatchReplay(t *testing.T) {
+	loader, url := makeTestLoader()
+	first := <-loader.Watch(context.Background(), url, time.Hour)
+	assert.NotNil(t, first.Data)
+	defer loader.Unwatch(url)
+
+	// Watching again replays the last update immediately
+	select {
+	case u := <-loader.Watch(context.Background(), url, time.Hour):
+		assert.Equal(t, first.Data, u.Data)
+		assert.NoError(t, u.Err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "replay timed out")
+	}
+}
+
+func TestWatchFanOut(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("v1"))
+	loader := New(WithDownloader("mem", mem))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := loader.Watch(ctx, "mem://test", 5*time.Millisecond)
+	second := loader.Watch(context.Background(), "mem://test", 5*time.Millisecond)
+	assert.Equal(t, 1, countWatchers(loader))
+
+	// Both subscribers receive the same updates
+	assert.Equal(t, "v1", string((<-first).Data))
+	assert.Equal(t, "v1", string((<-second).Data))
+	time.Sleep(10 * time.Millisecond)
+	mem.Put("mem://test", []byte("v2"))
+	assert.Equal(t, "v2", string((<-first).Data))
+	assert.Equal(t, "v2", string((<-second).Data))
+
+	// Cancelling the first subscriber does not stop the second one
+	cancel()
+	for range first {
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	mem.Put("mem://test", []byte("v3"))
+	assert.Equal(t, "v3", string((<-second).Data))
+
+	// The polling stops once the last subscriber leaves
+	assert.True(t, loader.Unwatch("mem://test"))
+	for range second {
+	}
+	assert.Equal(t, 0, countWatchers(loader))
+	assert.False(t, loader.Unwatch("mem://test"))
+}
+
+func TestWatchUnwatchStress(t *testing.T) {
+	logger := new(captureLogger)
+	loader := New(WithDownloader("test", new(changingDownloader)), WithLogger(logger))
+
+	// Watch and stop concurrently while the checks keep sending updates
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			uri := fmt.Sprintf("test://%d", i%4)
+			for n := 0; n < 50; n++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				updates := loader.Watch(ctx, uri, time.Millisecond)
+				select {
+				case <-updates:
+				case <-time.After(time.Millisecond):
+				}
+
+				if n%2 == 0 {
+					cancel()
+				} else {
+					loader.Unwatch(uri)
+					cancel()
+				}
+
+				// The channel is always closed eventually
+				for range updates {
+				}
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock while watching and unwatching")
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+	for _, line := range logger.lines {
+		assert.NotContains(t, line, "panic recovered")
+	}
+}
+
+func TestWatchFor(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("v1"))
+	loader := New(WithDownloader("mem", mem))
+
+	start := time.Now()
+	updates := loader.WatchFor(context.Background(), "mem://test", 5*time.Millisecond, 50*time.Millisecond)
+	assert.Equal(t, "v1", string((<-updates).Data))
+	assert.Equal(t, 1, countWatchers(loader))
+
+	// The channel is closed once the lifetime elapses, without Unwatch
+	for range updates {
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return countWatchers(loader) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, loader.Unwatch("mem://test"))
+}
+
+func TestWatchRelease(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("v1"))
+	loader := New(WithDownloader("mem", mem))
+
+	// The subscription is released as soon as it is stopped, not when the lifetime elapses
+	var released int64
+	updates := loader.watch(context.Background(), "mem://test", 5*time.Millisecond, func() {
+		atomic.AddInt64(&released, 1)
+	})
+	assert.Equal(t, "v1", string((<-updates).Data))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&released))
+
+	assert.True(t, loader.Unwatch("mem://test"))
+	for range updates {
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&released))
+}
+
+func TestSetInterval(t *testing.T) {
+	dl := new(countingDownloader)
+	loader := New(WithDownloader("test", dl))
+	loader.Watch(context.Background(), "test://hello", time.Hour)
+	defer loader.Unwatch("test://hello")
+	assert.False(t, loader.SetInterval("test://missing", time.Millisecond))
+
+	// Only the first check is done with the hourly interval
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dl.count))
+
+	// The checks become more frequent right away
+	assert.True(t, loader.SetInterval("test://hello", 5*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, atomic.LoadInt64(&dl.count), int64(5))
+
+	// And less frequent again
+	assert.True(t, loader.SetInterval("test://hello", time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	count := atomic.LoadInt64(&dl.count)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, atomic.LoadInt64(&dl.count))
+}
+
+func TestWatchETagShared(t *testing.T) {
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := etag.Load().(string)
+		w.Header().Set("ETag", current)
+		if r.Header.Get("If-None-Match") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Write([]byte(current))
+	}))
+	defer ts.Close()
+
+	loader := New()
+	updates := loader.Watch(context.Background(), ts.URL, time.Hour)
+	defer loader.Unwatch(ts.URL)
+	assert.Equal(t, `"v1"`, string((<-updates).Data))
+
+	// Another caller loads the new version first, which must not hide it from the watcher
+	etag.Store(`"v2"`)
+	b, err := loader.Load(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, `"v2"`, string(b))
+
+	loader.Reload(ts.URL)
+	assert.Equal(t, `"v2"`, string((<-updates).Data))
+}
+
+func TestWatchETagMatchShared(t *testing.T) {
+	var etag atomic.Value
+	var gets int64
+	etag.Store(`"v1"`)
+
+	// The server ignores the conditional headers and always responds with 200
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+		}
+
+		current := etag.Load().(string)
+		w.Header().Set("ETag", current)
+		w.Write([]byte(current))
+	}))
+	defer ts.Close()
+
+	loader := New(WithHTTP(loaderhttp.New(loaderhttp.WithETagMatch())))
+	updates := loader.Watch(context.Background(), ts.URL, time.Hour)
+	defer loader.Unwatch(ts.URL)
+	assert.Equal(t, `"v1"`, string((<-updates).Data))
+
+	// Another caller loads the new version first, which must not hide it from the watcher
+	etag.Store(`"v2"`)
+	b, err := loader.Load(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, `"v2"`, string(b))
+
+	loader.Reload(ts.URL)
+	assert.Equal(t, `"v2"`, string((<-updates).Data))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&gets))
+}
+
+func TestWatchContentHashShared(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "config.json")
+	mtime := time.Now().Add(-time.Hour)
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+		assert.NoError(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	write("v1")
+	loader := New(WithFile(file.New(file.WithContentHash())))
+	uri := "file:///" + filepath.ToSlash(f)
+	updates := loader.Watch(context.Background(), uri, time.Hour)
+	defer loader.Unwatch(uri)
+	assert.Equal(t, "v1", string((<-updates).Data))
+
+	// Another caller loads the new contents first, which must not hide them from the watcher
+	write("v2")
+	b, err := loader.Load(context.Background(), uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(b))
+
+	loader.Reload(uri)
+	assert.Equal(t, "v2", string((<-updates).Data))
+}
+
+func TestWatchSymlink(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	for _, release := range []string{"v1", "v2"} {
+		f := filepath.Join(dir, "releases", release, "config.json")
+		assert.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		assert.NoError(t, os.WriteFile(f, []byte(release), 0644))
+		assert.NoError(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	current := filepath.Join(dir, "current")
+	if err := os.Symlink(filepath.Join(dir, "releases", "v1"), current); err != nil {
+		t.Skip("symbolic links are not supported")
+	}
+
+	loader := New(WithFile(file.New(file.WithSymlinkTracking())))
+	uri := "file:///" + filepath.ToSlash(filepath.Join(current, "config.json"))
+	updates := loader.Watch(context.Background(), uri, 10*time.Millisecond)
+	defer loader.Unwatch(uri)
+	assert.Equal(t, "v1", string((<-updates).Data))
+
+	// Repoint the link atomically, the watcher fires despite the older file and another caller
+	// loading the new target first
+	tmp := current + ".tmp"
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "releases", "v2"), tmp))
+	assert.NoError(t, os.Rename(tmp, current))
+	b, err := loader.Load(context.Background(), uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(b))
+	assert.Equal(t, "v2", string((<-updates).Data))
+}
+
+func TestWatchPanic(t *testing.T) {
+	logger := new(captureLogger)
+	loader := New(WithDownloader("panic", panicDownloader{}), WithLogger(logger))
+	loader.Watch(context.Background(), "panic://test", time.Minute)
+	defer loader.Unwatch("panic://test")
+
+	assert.Len(t, logger.lines, 1)
+	assert.Contains(t, logger.lines[0], "panic recovered: boom")
+}
+
+func TestWatchPanicUpdate(t *testing.T) {
+	var hooked int64
+	loader := New(
+		WithDownloader("panic", panicDownloader{}),
+		WithLogger(new(captureLogger)),
+		WithPanicHook(func(uri string, recovered any) {
+			assert.Equal(t, "panic://test", uri)
+			assert.Equal(t, "boom", recovered)
+			atomic.AddInt64(&hooked, 1)
+		}),
+	)
+
+	updates := loader.Watch(context.Background(), "panic://test", time.Minute)
+	defer loader.Unwatch("panic://test")
+
+	// The panic is reported as an error update
+	u := <-updates
+	assert.ErrorIs(t, u.Err, ErrPanic)
+	assert.ErrorContains(t, u.Err, "boom")
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hooked))
+
+	// The watcher keeps going after the panic
+	assert.True(t, loader.Reload("panic://test"))
+	u = <-updates
+	assert.ErrorIs(t, u.Err, ErrPanic)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&hooked))
+
+	status, ok := loader.WatcherStatus("panic://test")
+	assert.True(t, ok)
+	assert.ErrorIs(t, status.LastError, ErrPanic)
+}
+
+func TestWatchDebounce(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("v0"))
+
+	loader := New(WithDownloader("mem", mem), WithDebounce(100*time.Millisecond))
+	updates := loader.Watch(context.Background(), "mem://test", 5*time.Millisecond)
+	defer loader.Unwatch("mem://test")
+
+	// The initial update is not debounced
+	u := <-updates
+	assert.Equal(t, "v0", string(u.Data))
+
+	// Three changes within the debounce window
+	for _, v := range []string{"v1", "v2", "v3"} {
+		time.Sleep(20 * time.Millisecond)
+		mem.Put("mem://test", []byte(v))
+	}
+
+	u = <-updates
+	assert.Equal(t, "v3", string(u.Data))
+
+	select {
+	case u := <-updates:
+		assert.Fail(t, "unexpected update", string(u.Data))
+	case <-time.After(250 * time.Millisecond):
+	}
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("v0"))
+	loader := New(WithDownloader("mem", mem), WithUpdateBuffer(2))
+
+	// Never read from the channel while the resource keeps changing
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := loader.Watch(ctx, "mem://test", 5*time.Millisecond)
+	assert.Equal(t, 2, cap(updates))
+	for i := 1; i <= 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		mem.Put("mem://test", []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	// The latest update is retained in the buffer
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, len(updates))
+
+	// The watcher still stops on cancel
+	cancel()
+	assert.Eventually(t, func() bool {
+		return countWatchers(loader) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	var last Update
+	for u := range updates {
+		last = u
+	}
+	assert.Equal(t, "v5", string(last.Data))
+}
+
+func TestObserver(t *testing.T) {
+	mem := memory.New()
+	mem.Put("mem://test", []byte("hello"))
+	mem.SetModTime("mem://test", time.Now().Add(-time.Hour))
+
+	observer := new(mockObserver)
+	loader := New(WithDownloader("mem", mem), WithObserver(observer))
+
+	{ // Load directly
+		_, err := loader.Load(context.Background(), "mem://test")
+		assert.NoError(t, err)
+		_, err = loader.Load(context.Background(), "mem://missing")
+		assert.Error(t, err)
+	}
+
+	{ // Watch, which emits once then checks without changes
+		<-loader.Watch(context.Background(), "mem://test", 5*time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+		loader.Unwatch("mem://test")
+	}
+
+	observer.Lock()
+	defer observer.Unlock()
+	assert.Equal(t, "mem", observer.downloads[0].scheme)
+	assert.Equal(t, 5, observer.downloads[0].bytes)
+	assert.NoError(t, observer.downloads[0].err)
+	assert.Equal(t, 0, observer.downloads[1].bytes)
+	assert.Error(t, observer.downloads[1].err)
+	assert.Equal(t, 5, observer.downloads[2].bytes)
+	assert.Equal(t, []bool{true, false}, observer.changed[:2])
+}
+
+func TestWatchInitialLoad(t *testing.T) {
+	{ // Missing file
+		loader := New(WithInitialLoad(true))
+		url := "file:///" + filepath.Join(t.TempDir(), "missing.txt")
+		defer loader.Unwatch(url)
+
+		select {
+		case u := <-loader.Watch(context.Background(), url, time.Minute):
+			assert.True(t, errors.Is(u.Err, ErrNotFound))
+			assert.True(t, errors.Is(u.Err, fs.ErrNotExist))
+		case <-time.After(time.Second):
+			assert.Fail(t, "expected an initial update")
+		}
+	}
+
+	{ // Nothing loaded
+		loader := New(WithInitialLoad(true), WithDownloader("count", new(countingDownloader)))
+		defer loader.Unwatch("count://test")
+
+		select {
+		case u := <-loader.Watch(context.Background(), "count://test", time.Minute):
+			assert.Equal(t, ErrNotFound, u.Err)
+		case <-time.After(time.Second):
+			assert.Fail(t, "expected an initial update")
+		}
+	}
+}
+
 func countWatchers(l *Loader) (count int) {
 	l.RangeWatchers(func(uri string) bool {
 		count++
@@ -148,7 +903,108 @@ func countWatchers(l *Loader) (count int) {
 	return
 }
 
+func touch(t *testing.T, path string, offset time.Duration) {
+	at := time.Now().Add(offset)
+	assert.NoError(t, os.Chtimes(path, at, at))
+}
+
 func makeTestLoader() (*Loader, string) {
 	f, _ := filepath.Abs("loader.go")
 	return New(), "file:///" + f
 }
+
+// blockingDownloader blocks until the context is done
+type blockingDownloader struct{}
+
+func (blockingDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// countingDownloader counts the checks and never reports any updates
+type countingDownloader struct {
+	count int64
+	delay time.Duration
+}
+
+func (d *countingDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	atomic.AddInt64(&d.count, 1)
+	time.Sleep(d.delay)
+	return nil, nil
+}
+
+// failingDownloader always fails and records the time of the checks
+type failingDownloader struct {
+	sync.Mutex
+	checks []time.Time
+}
+
+func (d *failingDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.checks = append(d.checks, time.Now())
+	return nil, errors.New("service unavailable")
+}
+
+// gaps returns the durations between the consecutive checks
+func (d *failingDownloader) gaps() (gaps []time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	for i := 1; i < len(d.checks); i++ {
+		gaps = append(gaps, d.checks[i].Sub(d.checks[i-1]))
+	}
+	return
+}
+
+// panicDownloader always panics
+type panicDownloader struct{}
+
+func (panicDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	panic("boom")
+}
+
+// captureLogger captures the log lines
+type captureLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// changingDownloader returns different contents on every call
+type changingDownloader struct {
+	count int64
+}
+
+func (d *changingDownloader) DownloadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	return []byte(fmt.Sprintf("v%d", atomic.AddInt64(&d.count, 1))), nil
+}
+
+// mockObserver records the events
+type mockObserver struct {
+	sync.Mutex
+	downloads []observedDownload
+	changed   []bool
+}
+
+type observedDownload struct {
+	scheme string
+	bytes  int
+	err    error
+}
+
+func (o *mockObserver) OnDownload(scheme string, bytes int, duration time.Duration, err error) {
+	o.Lock()
+	defer o.Unlock()
+	o.downloads = append(o.downloads, observedDownload{scheme, bytes, err})
+}
+
+func (o *mockObserver) OnWatchEvent(uri string, changed bool) {
+	o.Lock()
+	defer o.Unlock()
+	o.changed = append(o.changed, changed)
+}